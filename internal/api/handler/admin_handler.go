@@ -2,9 +2,9 @@ package handler
 
 import (
 	"my-chat/internal/service"
+	"my-chat/pkg/errno"
 
 	"github.com/gin-gonic/gin"
-	//"my-chat/pkg/errno"
 )
 
 type AdminHandler struct {
@@ -107,3 +107,39 @@ func (h *AdminHandler) AbleGroup(c *gin.Context) {
 	}
 	SendResponse(c, nil, gin.H{"msg": "操作成功"})
 }
+
+type DeadLetterReq struct {
+	Partition int   `json:"partition"`
+	Offset    int64 `json:"offset"`
+	Limit     int   `json:"limit"`
+}
+
+// 查看死信队列，从指定分区的offset往后读
+func (h *AdminHandler) GetDeadLetters(c *gin.Context) {
+	var req DeadLetterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	data, err := h.adminService.GetDeadLetters(req.Partition, req.Offset, req.Limit)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, data)
+}
+
+// 把一条死信重新投递到业务topic
+func (h *AdminHandler) ReplayDeadLetter(c *gin.Context) {
+	var req DeadLetterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	data, err := h.adminService.ReplayDeadLetter(req.Partition, req.Offset)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, data)
+}
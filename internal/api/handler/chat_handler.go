@@ -2,6 +2,7 @@ package handler
 
 import (
 	"my-chat/internal/service"
+	"my-chat/internal/websocket"
 	"my-chat/pkg/errno"
 
 	"github.com/gin-gonic/gin"
@@ -9,10 +10,11 @@ import (
 
 type ChatHandler struct {
 	chatService *service.ChatService
+	wsManager   *websocket.ClientManager
 }
 
-func NewChatHandler(chatService *service.ChatService) *ChatHandler {
-	return &ChatHandler{chatService: chatService}
+func NewChatHandler(chatService *service.ChatService, wsManager *websocket.ClientManager) *ChatHandler {
+	return &ChatHandler{chatService: chatService, wsManager: wsManager}
 }
 
 type HistoryReq struct {
@@ -44,3 +46,281 @@ func (h *ChatHandler) History(c *gin.Context) {
 	}
 	SendResponse(c, nil, messages)
 }
+
+type SyncReq struct {
+	Conversations []service.SyncCursor `json:"conversations" binding:"required,dive"`
+	Limit         int                  `json:"limit"`
+}
+
+// 断线重连后按会话增量同步
+func (h *ChatHandler) Sync(c *gin.Context) {
+	var req SyncReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	userId := c.GetString("userId")
+	if userId == "" {
+		SendResponse(c, errno.ErrTokenInvalid, nil)
+		return
+	}
+	results, err := h.chatService.SyncMessages(userId, req.Conversations, req.Limit)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, results)
+}
+
+type ThreadReq struct {
+	RootId string `json:"root_id" binding:"required"`
+	Seq    int64  `json:"seq"` //上一页最后一条的seq，第一页传0
+	Limit  int    `json:"limit"`
+}
+
+// 分页查看群话题
+func (h *ChatHandler) Thread(c *gin.Context) {
+	var req ThreadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	result, err := h.chatService.GetThread(c.GetString("userId"), req.RootId, req.Seq, req.Limit)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, result)
+}
+
+type ReadersReq struct {
+	MsgId string `json:"msg_id" binding:"required"`
+}
+
+// 消息的已读/未读成员列表
+func (h *ChatHandler) Readers(c *gin.Context) {
+	var req ReadersReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	userId := c.GetString("userId")
+	readers, err := h.chatService.GetMessageReaders(userId, req.MsgId)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, readers)
+}
+
+type EditReq struct {
+	MsgId   string `json:"msg_id" binding:"required"`
+	Content string `json:"content" binding:"required"`
+}
+
+// 编辑消息，成功后通过websocket推edited事件
+func (h *ChatHandler) Edit(c *gin.Context) {
+	var req EditReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	msg, err := h.chatService.EditMessage(c.GetString("userId"), req.MsgId, req.Content)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	h.wsManager.NotifyEdited(msg)
+	SendResponse(c, nil, gin.H{"msg_id": msg.Uuid, "edited_at": msg.EditedAt})
+}
+
+type RevisionsReq struct {
+	MsgId string `json:"msg_id" binding:"required"`
+}
+
+// 消息的历史版本
+func (h *ChatHandler) Revisions(c *gin.Context) {
+	var req RevisionsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	revisions, err := h.chatService.GetRevisions(c.GetString("userId"), req.MsgId)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, revisions)
+}
+
+type ReactReq struct {
+	MsgId string `json:"msg_id" binding:"required"`
+	Emoji string `json:"emoji" binding:"required"`
+	Op    string `json:"op" binding:"required,oneof=add remove"`
+}
+
+// 添加或取消表情回应，有变化时通过websocket推reaction事件
+func (h *ChatHandler) React(c *gin.Context) {
+	var req ReactReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	userId := c.GetString("userId")
+	msg, reactions, changed, err := h.chatService.ReactMessage(userId, req.MsgId, req.Emoji, req.Op == websocket.ReactionOpAdd)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	if changed {
+		h.wsManager.NotifyReaction(msg, userId, req.Emoji, req.Op, reactions)
+	}
+	SendResponse(c, nil, gin.H{"msg_id": msg.Uuid, "reactions": reactions})
+}
+
+// 所有群里未读的@我
+func (h *ChatHandler) Mentions(c *gin.Context) {
+	mentions, err := h.chatService.GetUnreadMentions(c.GetString("userId"))
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, mentions)
+}
+
+type ForwardReq struct {
+	MsgIds  []string                  `json:"msg_ids" binding:"required,min=1"`
+	Targets []websocket.ForwardTarget `json:"targets" binding:"required,min=1,dive"`
+	Merged  bool                      `json:"merged"` //true:合并转发 false:逐条转发
+	Title   string                    `json:"title" binding:"max=64"`
+}
+
+// 转发消息到好友或群，每个目标单独返回结果
+func (h *ChatHandler) Forward(c *gin.Context) {
+	var req ForwardReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	results, err := h.wsManager.Forward(c.GetString("userId"), req.MsgIds, req.Targets, req.Merged, req.Title)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, results)
+}
+
+type RecordReq struct {
+	MsgId string `json:"msg_id" binding:"required"`
+}
+
+// 展开合并转发的聊天记录
+func (h *ChatHandler) Record(c *gin.Context) {
+	var req RecordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	record, err := h.chatService.GetChatRecord(c.GetString("userId"), req.MsgId)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, record)
+}
+
+type PinReq struct {
+	MsgId string `json:"msg_id" binding:"required"`
+}
+
+// 置顶消息
+func (h *ChatHandler) Pin(c *gin.Context) {
+	h.setPin(c, true)
+}
+
+// 取消置顶
+func (h *ChatHandler) Unpin(c *gin.Context) {
+	h.setPin(c, false)
+}
+
+func (h *ChatHandler) setPin(c *gin.Context, pin bool) {
+	var req PinReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	userId := c.GetString("userId")
+	msg, changed, err := h.chatService.PinMessage(userId, req.MsgId, pin)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	if changed {
+		op := websocket.PinOpUnpin
+		if pin {
+			op = websocket.PinOpPin
+		}
+		h.wsManager.NotifyPinChanged(msg, userId, op)
+	}
+	SendResponse(c, nil, nil)
+}
+
+type PinsReq struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required"` //1-私聊 2-群聊
+}
+
+// 会话的置顶消息列表
+func (h *ChatHandler) Pins(c *gin.Context) {
+	var req PinsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	pins, err := h.chatService.ListPins(c.GetString("userId"), req.TargetId, req.Type)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, pins)
+}
+
+type SetTTLReq struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required"` //1-私聊 2-群聊
+	TTL      int64  `json:"ttl"`                     //阅后即焚时长，单位秒，0为关闭
+}
+
+// 设置会话的阅后即焚时长，会在会话里发一条系统提示
+func (h *ChatHandler) SetTTL(c *gin.Context) {
+	var req SetTTLReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	if err := h.wsManager.SetMessageTTL(c.GetString("userId"), req.TargetId, req.Type, req.TTL); err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, nil)
+}
+
+type SettingReq struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required"` //1-私聊 2-群聊
+}
+
+// 查看会话设置
+func (h *ChatHandler) Setting(c *gin.Context) {
+	var req SettingReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	setting, err := h.chatService.GetConversationSetting(c.GetString("userId"), req.TargetId, req.Type)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, setting)
+}
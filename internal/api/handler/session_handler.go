@@ -2,6 +2,7 @@ package handler
 
 import (
 	"my-chat/internal/service"
+	"my-chat/internal/websocket"
 	"my-chat/pkg/errno"
 
 	"github.com/gin-gonic/gin"
@@ -9,10 +10,11 @@ import (
 
 type SessionHandler struct {
 	sessionService *service.SessionService
+	wsManager      *websocket.ClientManager
 }
 
-func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
-	return &SessionHandler{sessionService: sessionService}
+func NewSessionHandler(sessionService *service.SessionService, wsManager *websocket.ClientManager) *SessionHandler {
+	return &SessionHandler{sessionService: sessionService, wsManager: wsManager}
 }
 func (h *SessionHandler) List(c *gin.Context) {
 	userId := c.GetString("userId")
@@ -32,3 +34,49 @@ type AddSessionReq struct {
 	TargetId string `json:"target_id" binding:"required"`
 	Type     string `json:"type" binding:"required"`
 }
+
+type ReadSessionReq struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required,oneof=1 2"` //1-私聊 2-群聊
+}
+
+// 标记会话已读，并同步给自己的其他设备
+func (h *SessionHandler) Read(c *gin.Context) {
+	var req ReadSessionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	userId := c.GetString("userId")
+	readSeq, err := h.sessionService.MarkRead(userId, req.TargetId, req.Type)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	h.wsManager.NotifySessionRead(userId, &websocket.SessionReadContent{
+		TargetId: req.TargetId,
+		Type:     req.Type,
+		ReadSeq:  readSeq,
+	})
+	SendResponse(c, nil, gin.H{"read_seq": readSeq})
+}
+
+type MuteSessionReq struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required"` //1-私聊 2-群聊
+	Mute     bool   `json:"mute"`
+}
+
+// 设置会话免打扰
+func (h *SessionHandler) Mute(c *gin.Context) {
+	var req MuteSessionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	if err := h.sessionService.SetMute(c.GetString("userId"), req.TargetId, req.Type, req.Mute); err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, nil)
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"my-chat/internal/service"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/storage"
+	"my-chat/pkg/zlog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type UploadHandler struct {
+	uploadService *service.UploadService
+	store         storage.Storage
+}
+
+func NewUploadHandler(uploadService *service.UploadService, store storage.Storage) *UploadHandler {
+	return &UploadHandler{uploadService: uploadService, store: store}
+}
+
+// 上传聊天附件，返回的file_id用于发送图片/语音/视频/文件消息
+func (h *UploadHandler) UploadFile(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	data, err := h.uploadService.UploadFile(c.GetString("userId"), file)
+	if err != nil {
+		if _, ok := err.(errno.Errno); ok {
+			SendResponse(c, err, nil)
+			return
+		}
+		zlog.Error("upload attachment failed", zap.Error(err))
+		SendResponse(c, errno.InternalServerError, nil)
+		return
+	}
+	SendResponse(c, nil, data)
+}
+
+type FileReq struct {
+	FileId string `form:"file_id" binding:"required"`
+	Thumb  bool   `form:"thumb"`
+}
+
+// 下载附件：校验权限后跳转到限时签名地址，可以直接用在<img src>里（token放query）
+func (h *UploadHandler) Download(c *gin.Context) {
+	var req FileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	url, err := h.uploadService.SignDownload(c.GetString("userId"), req.FileId, req.Thumb)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
+
+// 只拿签名地址，不跳转
+func (h *UploadHandler) Sign(c *gin.Context) {
+	var req FileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	url, err := h.uploadService.SignDownload(c.GetString("userId"), req.FileId, req.Thumb)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, gin.H{"url": url})
+}
+
+// 本地存储的签名地址落到这里，S3存储的签名地址直接指向对象存储
+func (h *UploadHandler) Raw(c *gin.Context) {
+	local, ok := h.store.(*storage.LocalStorage)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if !local.Verify(key, expires, c.Query("sig")) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	file, err := local.Open(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		zlog.Error("open attachment failed", zap.String("key", key), zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		zlog.Warn("write attachment failed", zap.String("key", key), zap.Error(err))
+	}
+}
+
+type InitUploadReq struct {
+	FileName string `json:"file_name" binding:"required"`
+	Size     int64  `json:"size" binding:"required"`
+	Sha256   string `json:"sha256" binding:"required"` //整个文件的sha256，十六进制
+}
+
+// 开始分片上传
+func (h *UploadHandler) InitUpload(c *gin.Context) {
+	var req InitUploadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	data, err := h.uploadService.InitUpload(c.GetString("userId"), req.FileName, req.Size, req.Sha256)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, data)
+}
+
+type UploadChunkReq struct {
+	UploadId string `form:"upload_id" binding:"required"`
+	Index    *int   `form:"index" binding:"required"`
+}
+
+// 上传一个分片，multipart表单：upload_id、index、chunk
+func (h *UploadHandler) UploadChunk(c *gin.Context) {
+	var req UploadChunkReq
+	if err := c.ShouldBind(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	chunk, err := c.FormFile("chunk")
+	if err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	src, err := chunk.Open()
+	if err != nil {
+		SendResponse(c, errno.InternalServerError, nil)
+		return
+	}
+	defer src.Close()
+	if err := h.uploadService.UploadChunk(c.GetString("userId"), req.UploadId, *req.Index, src, chunk.Size); err != nil {
+		if _, ok := err.(errno.Errno); !ok {
+			zlog.Error("upload chunk failed", zap.String("upload_id", req.UploadId), zap.Error(err))
+			err = errno.InternalServerError
+		}
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, nil)
+}
+
+type UploadIdReq struct {
+	UploadId string `form:"upload_id" json:"upload_id" binding:"required"`
+}
+
+// 查询已收到的分片
+func (h *UploadHandler) UploadStatus(c *gin.Context) {
+	var req UploadIdReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	data, err := h.uploadService.GetUploadStatus(c.GetString("userId"), req.UploadId)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, data)
+}
+
+// 所有分片传完后合并，返回和普通上传一样的附件信息
+func (h *UploadHandler) CompleteUpload(c *gin.Context) {
+	var req UploadIdReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	data, err := h.uploadService.CompleteUpload(c.GetString("userId"), req.UploadId)
+	if err != nil {
+		if _, ok := err.(errno.Errno); !ok {
+			zlog.Error("complete upload failed", zap.String("upload_id", req.UploadId), zap.Error(err))
+			err = errno.InternalServerError
+		}
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, data)
+}
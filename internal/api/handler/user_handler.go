@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"mime/multipart"
 	"my-chat/pkg/errno"
 	"my-chat/pkg/upload"
 
@@ -13,11 +14,12 @@ import (
 )
 
 type UserHandler struct {
-	userService *service.UserService
+	userService     *service.UserService
+	presenceService *service.PresenceService
 }
 
-func NewUserHandler(userService *service.UserService) *UserHandler {
-	return &UserHandler{userService: userService}
+func NewUserHandler(userService *service.UserService, presenceService *service.PresenceService) *UserHandler {
+	return &UserHandler{userService: userService, presenceService: presenceService}
 }
 
 type RegisterRequest struct {
@@ -101,7 +103,8 @@ func (h *UserHandler) UploadAvatar(c *gin.Context) {
 		SendResponse(c, errno.ErrBind, nil)
 		return
 	}
-	if !upload.CheckImageExt(file.Filename) {
+	//扩展名和文件内容都得是图片
+	if !upload.CheckImageExt(file.Filename) || !isImageFile(file) {
 		SendResponse(c, errno.New(400, "不支持的文件格式"), nil)
 		return
 	}
@@ -140,3 +143,35 @@ func (h *UserHandler) UpdateUserInfo(c *gin.Context) {
 	}
 	SendResponse(c, nil, gin.H{"message": "用户信息更新成功"})
 }
+
+type PresenceReq struct {
+	UserIds []string `json:"user_ids" binding:"required"`
+}
+
+// 批量查询在线状态
+func (h *UserHandler) GetPresence(c *gin.Context) {
+	var req PresenceReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendResponse(c, errno.ErrBind, nil)
+		return
+	}
+	list, err := h.presenceService.GetPresence(c.GetString("userId"), req.UserIds)
+	if err != nil {
+		SendResponse(c, err, nil)
+		return
+	}
+	SendResponse(c, nil, list)
+}
+
+func isImageFile(file *multipart.FileHeader) bool {
+	src, err := file.Open()
+	if err != nil {
+		return false
+	}
+	defer src.Close()
+	mimeType, err := upload.SniffMime(src)
+	if err != nil {
+		return false
+	}
+	return upload.Category(mimeType) == upload.CategoryImage
+}
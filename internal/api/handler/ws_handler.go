@@ -58,10 +58,18 @@ func (h *WSHandler) Connect(c *gin.Context) {
 		zlog.Error("webSocket upgrade failed", zap.Error(err))
 		return
 	}
+	//多端登录：设备ID区分连接，不传就按设备类型算，同类型设备互相顶替
+	deviceType := websocket.NormalizeDeviceType(c.Query("device_type"))
+	deviceId := c.Query("device_id")
+	if deviceId == "" {
+		deviceId = deviceType
+	}
 	client := &websocket.Client{
 		Manager:       h.manager,
 		Conn:          conn,
 		UserId:        userId,
+		DeviceId:      deviceId,
+		DeviceType:    deviceType,
 		Send:          make(chan []byte, 256),
 		HeartbeatTime: time.Now().Unix(),
 	}
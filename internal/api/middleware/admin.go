@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"my-chat/internal/api/handler"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/zlog"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AdminAuth 只允许配置里的管理员访问，必须放在Auth之后
+func AdminAuth(adminIds []string) gin.HandlerFunc {
+	admins := make(map[string]bool, len(adminIds))
+	for _, id := range adminIds {
+		if id != "" {
+			admins[id] = true
+		}
+	}
+	return func(c *gin.Context) {
+		userId := c.GetString("userId")
+		if !admins[userId] {
+			zlog.Warn("admin access denied",
+				zap.String("userId", userId),
+				zap.String("path", c.Request.URL.Path))
+			handler.SendResponse(c, errno.ErrAdminDenied, nil)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -10,11 +10,14 @@ import (
 // Register registers all HTTP routes.
 func Register(r *gin.Engine, userHandler *handler.UserHandler, wsHandler *handler.WSHandler,
 	groupHandler *handler.GroupHandler, chatHandler *handler.ChatHandler, contactHandler *handler.ContactHandler,
-	sessionHandler *handler.SessionHandler, adminHandler *handler.AdminHandler,
+	sessionHandler *handler.SessionHandler, adminHandler *handler.AdminHandler, uploadHandler *handler.UploadHandler,
+	adminIds []string,
 ) {
 	v1 := r.Group("/api/v1")
 	{
 		v1.POST("/register", userHandler.Register)
+		// 签名地址自带鉴权
+		v1.GET("/file/raw/*key", uploadHandler.Raw)
 		v1.POST("/login", userHandler.Login)
 		v1.POST("/refresh-token", userHandler.RefreshToken)
 	}
@@ -25,7 +28,15 @@ func Register(r *gin.Engine, userHandler *handler.UserHandler, wsHandler *handle
 		authGroup.GET("/ws", wsHandler.Connect)
 		// 用户相关
 		authGroup.POST("/upload/avatar", userHandler.UploadAvatar)
+		authGroup.POST("/upload/file", uploadHandler.UploadFile)
+		authGroup.POST("/upload/chunk/init", uploadHandler.InitUpload)
+		authGroup.POST("/upload/chunk", uploadHandler.UploadChunk)
+		authGroup.GET("/upload/chunk/status", uploadHandler.UploadStatus)
+		authGroup.POST("/upload/chunk/complete", uploadHandler.CompleteUpload)
+		authGroup.GET("/file/download", uploadHandler.Download)
+		authGroup.GET("/file/sign", uploadHandler.Sign)
 		authGroup.POST("/user/updateUserInfo", userHandler.UpdateUserInfo)
+		authGroup.POST("/user/presence", userHandler.GetPresence)
 		// 群组相关
 		authGroup.POST("/group/create", groupHandler.Create)
 		authGroup.POST("/group/join", groupHandler.Join)
@@ -46,8 +57,24 @@ func Register(r *gin.Engine, userHandler *handler.UserHandler, wsHandler *handle
 		authGroup.POST("/contact/cancelBlackContact", contactHandler.UnBlackContact)
 		// 聊天历史记录
 		authGroup.POST("/chat/history", chatHandler.History)
+		authGroup.POST("/chat/sync", chatHandler.Sync)
+		authGroup.POST("/chat/readers", chatHandler.Readers)
+		authGroup.POST("/chat/edit", chatHandler.Edit)
+		authGroup.POST("/chat/revisions", chatHandler.Revisions)
+		authGroup.POST("/chat/thread", chatHandler.Thread)
+		authGroup.POST("/chat/react", chatHandler.React)
+		authGroup.POST("/chat/mentions", chatHandler.Mentions)
+		authGroup.POST("/chat/forward", chatHandler.Forward)
+		authGroup.POST("/chat/record", chatHandler.Record)
+		authGroup.POST("/chat/pin", chatHandler.Pin)
+		authGroup.POST("/chat/unpin", chatHandler.Unpin)
+		authGroup.POST("/chat/pins", chatHandler.Pins)
+		authGroup.POST("/chat/ttl", chatHandler.SetTTL)
+		authGroup.POST("/chat/setting", chatHandler.Setting)
 		// 会话接口
 		authGroup.POST("/session/list", sessionHandler.List)
+		authGroup.POST("/session/read", sessionHandler.Read)
+		authGroup.POST("/session/mute", sessionHandler.Mute)
 		// Admin User
 		authGroup.POST("/user/getUserInfoList", adminHandler.GetUserList)
 		authGroup.POST("/user/disableUsers", adminHandler.DisableUser)
@@ -57,4 +84,12 @@ func Register(r *gin.Engine, userHandler *handler.UserHandler, wsHandler *handle
 		authGroup.POST("/group/disableGroup", adminHandler.DisableGroup)
 		authGroup.POST("/group/ableGroups", adminHandler.AbleGroup)
 	}
+
+	// 运维接口：死信里是原始消息内容，只有配置的管理员能看
+	adminGroup := authGroup.Group("/admin")
+	adminGroup.Use(middleware.AdminAuth(adminIds))
+	{
+		adminGroup.POST("/dlq/list", adminHandler.GetDeadLetters)
+		adminGroup.POST("/dlq/replay", adminHandler.ReplayDeadLetter)
+	}
 }
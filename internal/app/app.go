@@ -14,7 +14,8 @@ import (
 
 type App struct {
 	HTTPServer *http.Server
-	WSStart    func() // starts ws manager loops
+	WSStart    func()                      // starts ws manager loops
+	Background []func(ctx context.Context) // other long-running jobs, each in its own goroutine, stop when ctx is done
 	Deps       *bootstrap.Deps
 }
 
@@ -25,6 +26,9 @@ func (a *App) Run(ctx context.Context) error {
 	if a.WSStart != nil {
 		go a.WSStart()
 	}
+	for _, job := range a.Background {
+		go job(ctx)
+	}
 
 	errCh := make(chan error, 1)
 	go func() {
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"my-chat/internal/config"
+	"my-chat/pkg/storage"
+	"my-chat/pkg/zlog"
+)
+
+// 本地存储签名地址对应的下载路由
+const localRawURL = "/api/v1/file/raw"
+
+// 以前配置文件里的占位值，还在用的话谁都能伪造下载地址
+const defaultSignSecret = "change-me"
+
+func newStorage(cfg *config.StorageConfig) (storage.Storage, error) {
+	switch cfg.Type {
+	case "", "local":
+		if cfg.SignSecret == defaultSignSecret {
+			return nil, fmt.Errorf("storage.sign_secret must not be %q, set a random value or %s", defaultSignSecret, config.SignSecretEnv)
+		}
+		//本地开发没配就随机生成一个，重启后之前签出去的地址失效，多节点之间也不通用
+		if cfg.SignSecret == "" {
+			secret, err := randomSecret()
+			if err != nil {
+				return nil, err
+			}
+			cfg.SignSecret = secret
+			zlog.Warn("storage.sign_secret is empty, using a random secret for this process; set " + config.SignSecretEnv + " in production")
+		}
+		dir := cfg.Local.Dir
+		if dir == "" {
+			dir = "data/files"
+		}
+		return storage.NewLocalStorage(dir, localRawURL, cfg.SignSecret), nil
+	case "s3":
+		return storage.NewS3Storage(storage.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			PathStyle: cfg.S3.PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", cfg.Type)
+	}
+}
+
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
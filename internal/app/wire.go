@@ -8,11 +8,13 @@ import (
 	"my-chat/internal/api/router"
 	"my-chat/internal/bootstrap"
 	"my-chat/internal/config"
+	"my-chat/internal/mq"
 	"my-chat/internal/repo"
 	"my-chat/internal/service"
 	"my-chat/internal/websocket"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -35,42 +37,74 @@ func New(ctx context.Context, cfg *config.Config) (*App, error) {
 	contactRepo := repo.NewContactRepository(deps.DB)
 	sessionRepo := repo.NewSessionRepository(deps.DB, deps.Redis)
 	adminRepo := repo.NewAdminRepository(deps.DB)
+	pendingRepo := repo.NewPendingRepository(deps.Redis)
+	seqRepo := repo.NewSeqRepository(deps.DB, deps.Redis)
+	presenceRepo := repo.NewPresenceRepository(deps.Redis)
+	routeRepo := repo.NewRouteRepository(deps.Redis)
+	dedupRepo := repo.NewDedupRepository(deps.Redis)
+	attachmentRepo := repo.NewAttachmentRepository(deps.DB)
+	uploadRepo := repo.NewUploadRepository(deps.Redis)
+	reactionRepo := repo.NewReactionRepository(deps.DB)
+	mentionRepo := repo.NewMentionRepository(deps.DB)
+	pinRepo := repo.NewPinRepository(deps.DB)
+	convSettingRepo := repo.NewConvSettingRepository(deps.DB, deps.Redis)
+
+	// storage
+	store, err := newStorage(&cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
 
 	// services
 	userService := service.NewUserService(userRepo)
-	chatService := service.NewChatService(msgRepo, groupRepo)
-	groupService := service.NewGroupService(groupRepo, userRepo)
+	chatService := service.NewChatService(msgRepo, groupRepo, seqRepo, sessionRepo, userRepo, contactRepo, dedupRepo, attachmentRepo, reactionRepo, mentionRepo, pinRepo, convSettingRepo, &cfg.Chat)
+	groupService := service.NewGroupService(groupRepo, userRepo, seqRepo)
 	contactService := service.NewContactService(contactRepo, userRepo)
-	sessionService := service.NewSessionService(sessionRepo, groupRepo, userRepo)
-	adminService := service.NewAdminService(adminRepo)
+	sessionService := service.NewSessionService(sessionRepo, groupRepo, userRepo, seqRepo, mentionRepo, contactRepo)
+	adminService := service.NewAdminService(adminRepo, deps.Kafka)
+	presenceService := service.NewPresenceService(presenceRepo, contactRepo)
+	uploadService := service.NewUploadService(attachmentRepo, uploadRepo, msgRepo, store, &cfg.Upload,
+		time.Duration(cfg.Storage.URLExpire)*time.Second)
 
 	// websocket manager
-	wsManager := websocket.NewClientManager(chatService, sessionRepo, deps.Kafka)
+	nodeId := cfg.App.NodeId
+	if nodeId == "" {
+		nodeId = fmt.Sprintf("node-%d", cfg.App.Machine)
+	}
+	nodeBus := mq.NewNodeBus(deps.Redis)
+	wsManager := websocket.NewClientManager(chatService, sessionService, presenceService, sessionRepo, groupRepo, pendingRepo, routeRepo,
+		deps.Kafka, nodeBus, nodeId, &cfg.Chat)
 	wsStart := func() {
 		// Start() already starts consumer/heartbeat internally.
 		wsManager.Start()
 	}
+	// other background jobs
+	background := []func(ctx context.Context){
+		uploadService.StartUploadSweeper,
+		wsManager.StartExpireSweeper,
+	}
 
 	// handlers
-	userHandler := handler.NewUserHandler(userService)
+	userHandler := handler.NewUserHandler(userService, presenceService)
 	wsHandler := handler.NewWSHandler(wsManager)
 	groupHandler := handler.NewGroupHandler(groupService)
-	chatHandler := handler.NewChatHandler(chatService)
+	chatHandler := handler.NewChatHandler(chatService, wsManager)
 	contactHandler := handler.NewContactHandler(contactService)
-	sessionHandler := handler.NewSessionHandler(sessionService)
+	sessionHandler := handler.NewSessionHandler(sessionService, wsManager)
 	adminHandler := handler.NewAdminHandler(adminService)
+	uploadHandler := handler.NewUploadHandler(uploadService, store)
 
 	// gin engine
 	r := gin.New()
 	r.Use(middleware.GinLogger())
 	r.Use(gin.Recovery())
 	r.Static("/static", "./static")
-	router.Register(r, userHandler, wsHandler, groupHandler, chatHandler, contactHandler, sessionHandler, adminHandler)
+	router.Register(r, userHandler, wsHandler, groupHandler, chatHandler, contactHandler, sessionHandler, adminHandler, uploadHandler, cfg.App.AdminIds)
 
 	port := cfg.App.Port
 	addr := ":" + strconv.FormatInt(port, 10)
 
 	httpSrv := &http.Server{Addr: addr, Handler: r}
 
-	return &App{HTTPServer: httpSrv, WSStart: wsStart, Deps: deps}, nil
+	return &App{HTTPServer: httpSrv, WSStart: wsStart, Background: background, Deps: deps}, nil
 }
@@ -7,11 +7,14 @@ import (
 )
 
 type Config struct {
-	MySQL MySQLConfig
-	Log   LogConfig
-	Redis RedisConfig
-	Kafka KafkaConfig
-	App   AppConfig
+	MySQL   MySQLConfig
+	Log     LogConfig
+	Redis   RedisConfig
+	Kafka   KafkaConfig
+	App     AppConfig
+	Chat    ChatConfig
+	Upload  UploadConfig
+	Storage StorageConfig
 }
 type MySQLConfig struct {
 	Host     string
@@ -36,21 +39,65 @@ type RedisConfig struct {
 }
 
 type KafkaConfig struct {
-	Addr  string
-	Topic string
-	Group string
+	Addr     string
+	Topic    string
+	Group    string
+	DLQTopic string `mapstructure:"dlq_topic"` //死信topic，不填默认为 topic + ".dlq"
+}
+type UploadConfig struct {
+	MaxImageSize int64 `mapstructure:"max_image_size"` //单位MB
+	MaxAudioSize int64 `mapstructure:"max_audio_size"`
+	MaxVideoSize int64 `mapstructure:"max_video_size"`
+	MaxFileSize  int64 `mapstructure:"max_file_size"`
+	ThumbSize    int   `mapstructure:"thumb_size"` //缩略图长边像素
+}
+type StorageConfig struct {
+	Type       string           `mapstructure:"type"`        //local | s3
+	SignSecret string           `mapstructure:"sign_secret"` //本地存储签名下载地址用，生产环境必须配置，可用环境变量MYCHAT_SIGN_SECRET覆盖
+	URLExpire  int64            `mapstructure:"url_expire"`  //签名地址有效期，单位秒
+	Local      LocalStoreConfig `mapstructure:"local"`
+	S3         S3StoreConfig    `mapstructure:"s3"`
+}
+type LocalStoreConfig struct {
+	Dir string `mapstructure:"dir"`
+}
+type S3StoreConfig struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	PathStyle bool   `mapstructure:"path_style"`
 }
 type AppConfig struct {
-	Machine int64 `mapstructure:"machine_id"`
-	Port    int64 `mapstructure:"port"`
+	Machine int64  `mapstructure:"machine_id"`
+	Port    int64  `mapstructure:"port"`
+	NodeId  string `mapstructure:"node_id"` //网关节点ID，多实例部署时必须唯一，不填按machine_id生成
+	//运维管理员的用户UUID，只有他们能访问死信队列等运维接口
+	AdminIds []string `mapstructure:"admin_ids"`
+}
+
+type ChatConfig struct {
+	RecallWindow  int64 `mapstructure:"recall_window"`  //撤回时限，单位秒
+	AckTimeout    int64 `mapstructure:"ack_timeout"`    //等待ACK的超时时间，单位秒，超时重传
+	MaxRetransmit int   `mapstructure:"max_retransmit"` //在线期间最大重传次数
+	//互斥登录的设备类别(web/mobile/desktop)，同类别只允许一台设备在线
+	ExclusiveDevices []string `mapstructure:"exclusive_devices"`
+	DedupWindow      int64    `mapstructure:"dedup_window"` //客户端消息ID去重窗口，单位秒
+	EditWindow       int64    `mapstructure:"edit_window"`  //发送后多久内可以编辑，单位秒
+	MaxPins          int      `mapstructure:"max_pins"`     //每个会话最多置顶几条消息
 }
 
 var GlobalConfig *Config
 
+// 签名密钥不写进配置文件，部署时通过环境变量注入，多节点必须一致
+const SignSecretEnv = "MYCHAT_SIGN_SECRET"
+
 func InitConfig() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
+	_ = viper.BindEnv("storage.sign_secret", SignSecretEnv)
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Error read config file, %s", err)
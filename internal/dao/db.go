@@ -29,6 +29,13 @@ func NewMySQL(c *config.MySQLConfig) (*gorm.DB, error) {
 		&model.Contact{},
 		&model.ContactApply{},
 		&model.Session{},
+		&model.Attachment{},
+		&model.MessageRevision{},
+		&model.MessageReaction{},
+		&model.Mention{},
+		&model.PinnedMessage{},
+		&model.ConversationSetting{},
+		&model.MessageFileRef{},
 	)
 	if err != nil {
 		return nil, err
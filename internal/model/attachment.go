@@ -0,0 +1,35 @@
+package model
+
+import "gorm.io/gorm"
+
+// 聊天附件，上传后发消息时通过Uuid引用，只能由上传者自己引用
+type Attachment struct {
+	gorm.Model
+	Uuid    string `gorm:"type:varchar(64);uniqueIndex;not null;comment:附件唯一标识"`
+	OwnerId string `gorm:"type:varchar(64);index;not null;comment:上传者UUID"`
+	Name    string `gorm:"type:varchar(255);comment:原始文件名"`
+	Key     string `gorm:"type:varchar(255);not null;comment:存储key"`
+	Mime    string `gorm:"type:varchar(128);comment:文件类型"`
+	Size    int64  `gorm:"comment:文件大小，单位字节"`
+	//内容sha256，同一用户重复上传同一文件直接复用
+	Hash     string `gorm:"type:char(64);index;comment:内容哈希"`
+	Width    int    `gorm:"default:0;comment:图片宽"`
+	Height   int    `gorm:"default:0;comment:图片高"`
+	ThumbKey string `gorm:"type:varchar(255);default:'';comment:缩略图存储key"`
+}
+
+// 附件统一通过鉴权的下载接口访问，不暴露存储地址
+const AttachmentDownloadPath = "/api/v1/file/download"
+
+// 消息里引用附件的地址，客户端带上token访问，服务端校验后跳转到限时签名地址
+func AttachmentURL(fileId string, thumb bool) string {
+	u := AttachmentDownloadPath + "?file_id=" + fileId
+	if thumb {
+		u += "&thumb=1"
+	}
+	return u
+}
+
+func (Attachment) TableName() string {
+	return "attachments"
+}
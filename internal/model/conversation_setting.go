@@ -0,0 +1,16 @@
+package model
+
+import "gorm.io/gorm"
+
+// 会话级别的设置，单聊双方共用一份
+type ConversationSetting struct {
+	gorm.Model
+	ConvId     string `gorm:"type:varchar(140);uniqueIndex;not null;comment:会话ID"`
+	Type       int    `gorm:"type:tinyint;default:1;comment:会话类型 1:单聊 2:群聊"`
+	MessageTTL int64  `gorm:"default:0;comment:阅后即焚时长，单位秒，0为关闭"`
+	UpdatedBy  string `gorm:"type:varchar(64);default:'';comment:最后修改人UUID"`
+}
+
+func (ConversationSetting) TableName() string {
+	return "conversation_settings"
+}
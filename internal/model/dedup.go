@@ -0,0 +1,7 @@
+package model
+
+// 客户端消息ID对应的服务端消息，存redis，客户端重发时原样返回
+type SendRecord struct {
+	MsgId     string `json:"msg_id"`    //服务端分配的消息ID
+	Timestamp int64  `json:"timestamp"` //服务端接收时间
+}
@@ -0,0 +1,31 @@
+package model
+
+// 聊天记录消息引用的附件，下载鉴权时和messages.file_id一起查
+// 普通消息只引用一个附件，直接存在messages.file_id上，不写这张表
+type MessageFileRef struct {
+	ID      uint   `gorm:"primarykey"`
+	MsgUuid string `gorm:"type:varchar(64);uniqueIndex:idx_msg_file;not null;comment:消息UUID"`
+	FileId  string `gorm:"type:varchar(64);uniqueIndex:idx_msg_file;index;not null;comment:附件ID"`
+}
+
+func (MessageFileRef) TableName() string {
+	return "message_file_refs"
+}
+
+// 聊天记录快照里引用的附件，同一个附件只记一次
+func (m *Message) FileRefs() []*MessageFileRef {
+	record := m.ChatRecord()
+	if record == nil {
+		return nil
+	}
+	var refs []*MessageFileRef
+	seen := make(map[string]bool)
+	for _, item := range record.Items {
+		if item.Media == nil || item.Media.FileId == "" || seen[item.Media.FileId] {
+			continue
+		}
+		seen[item.Media.FileId] = true
+		refs = append(refs, &MessageFileRef{MsgUuid: m.Uuid, FileId: item.Media.FileId})
+	}
+	return refs
+}
@@ -4,6 +4,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// 群状态
+const (
+	GroupStatusNormal   = 1 //正常
+	GroupStatusDisabled = 2 //禁用
+)
+
 type Group struct {
 	gorm.Model
 	Uuid     string `gorm:"type:varchar(64);uniqueIndex;not null;comment:群唯一标识"`
@@ -13,6 +19,7 @@ type Group struct {
 	Avatar   string `gorm:"type:varchar(255);comment:群头像"`
 	LastMsg  string `gorm:"type:text"`
 	LastTime int64  `gorm:"index"`
+	Status   int    `gorm:"default:1;comment:状态1：正常 2：禁用"`
 }
 
 func (Group) TableName() string {
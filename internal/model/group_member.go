@@ -16,6 +16,7 @@ type GroupMember struct {
 	UserId   string `gorm:"type:varchar(64);not null;index:idx_group_member;comment:用户UUID"`
 	Nickname string `gorm:"type:varchar(64);comment:群内昵称"`
 	Role     int    `gorm:"type:tinyint;default:0;comment:角色 0:成员 1:管理"`
+	ReadSeq  int64  `gorm:"default:0;comment:已读到的群消息seq"`
 }
 
 func (GroupMember) TableName() string {
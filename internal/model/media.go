@@ -0,0 +1,51 @@
+package model
+
+import "encoding/json"
+
+// 富媒体消息的附加信息，序列化后存在messages.extra里
+type MediaInfo struct {
+	FileId    string  `json:"file_id,omitempty"` //上传后返回的附件ID
+	Url       string  `json:"url,omitempty"`
+	Name      string  `json:"name,omitempty"` //文件名
+	Size      int64   `json:"size,omitempty"` //字节
+	Mime      string  `json:"mime,omitempty"`
+	Width     int     `json:"width,omitempty"`    //图片/视频宽
+	Height    int     `json:"height,omitempty"`   //图片/视频高
+	Duration  int     `json:"duration,omitempty"` //语音/视频时长，秒
+	Thumbnail string  `json:"thumbnail,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+// 解析消息上的富媒体信息，没有或者是聊天记录时返回nil
+func (m *Message) MediaInfo() *MediaInfo {
+	if m.Extra == "" || m.MediaType == MediaTypeRecord {
+		return nil
+	}
+	var media MediaInfo
+	if err := json.Unmarshal([]byte(m.Extra), &media); err != nil {
+		return nil
+	}
+	return &media
+}
+
+// 会话列表里展示的摘要，非文本消息用占位文字
+func MessagePreview(mediaType int, content string) string {
+	switch mediaType {
+	case MediaTypeImage:
+		return "[图片]"
+	case MediaTypeAudio:
+		return "[语音]"
+	case MediaTypeVideo:
+		return "[视频]"
+	case MediaTypeFile:
+		return "[文件]"
+	case MediaTypeLocation:
+		return "[位置]"
+	case MediaTypeRecord:
+		return "[聊天记录]"
+	default:
+		return content
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// 群会话上提示有人@我的标记
+const MentionMarker = "[有人@我]"
+
+// 群消息里的@记录，@所有人只记一条，UserId为空
+// 是否已读不单独存，seq大于成员在群里的已读seq即为未读
+type Mention struct {
+	gorm.Model
+	GroupId    string `gorm:"type:varchar(64);index:idx_group_seq;not null;comment:群组UUID"`
+	Seq        int64  `gorm:"index:idx_group_seq;default:0;comment:消息在群内的seq"`
+	UserId     string `gorm:"type:varchar(64);index;default:'';comment:被@的用户UUID，为空表示@所有人"`
+	MsgUuid    string `gorm:"type:varchar(64);index;not null;comment:消息UUID"`
+	FromUserId string `gorm:"type:varchar(64);not null;comment:发送者UUID"`
+}
+
+func (Mention) TableName() string {
+	return "group_mentions"
+}
+
+// 被@的用户ID列表序列化后存在messages.mention_ids里
+func EncodeMentionIds(userIds []string) string {
+	if len(userIds) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(userIds)
+	return string(data)
+}
+
+func (m *Message) MentionUserIds() []string {
+	if m.MentionIds == "" {
+		return nil
+	}
+	var userIds []string
+	_ = json.Unmarshal([]byte(m.MentionIds), &userIds)
+	return userIds
+}
+
+// 根据消息生成@记录
+func (m *Message) Mentions() []*Mention {
+	if m.Type != MsgTypeGroup {
+		return nil
+	}
+	var mentions []*Mention
+	newMention := func(userId string) *Mention {
+		return &Mention{GroupId: m.ToId, Seq: m.Seq, UserId: userId, MsgUuid: m.Uuid, FromUserId: m.FromUserId}
+	}
+	if m.MentionAll {
+		mentions = append(mentions, newMention(""))
+	}
+	for _, userId := range m.MentionUserIds() {
+		mentions = append(mentions, newMention(userId))
+	}
+	return mentions
+}
@@ -13,24 +13,68 @@ const (
 
 // 消息内容类型
 const (
-	MediaTypeText  = 1 //文本
-	MediaTypeImage = 2 //图片
-	MediaTypeAudio = 3 //语音
+	MediaTypeText     = 1 //文本
+	MediaTypeImage    = 2 //图片
+	MediaTypeAudio    = 3 //语音
+	MediaTypeVideo    = 4 //视频
+	MediaTypeFile     = 5 //文件
+	MediaTypeLocation = 6 //位置
+	MediaTypeRecord   = 7 //合并转发的聊天记录，只能由服务端生成
+	MediaTypeSystem   = 8 //系统提示，比如修改了会话设置，只能由服务端生成
 )
 
+// 消息状态
+const (
+	MsgStatusNormal   = 0 //正常
+	MsgStatusRecalled = 1 //已撤回
+)
+
+// 撤回后展示的占位文本
+const RecalledPlaceholder = "[消息已撤回]"
+
 type Message struct {
 	gorm.Model
-	Uuid       string `gorm:"type:varchar(64);uniqueIndex;not null;comment:消息唯一标识"`
-	FromUserId string `gorm:"type:varchar(64);index;not null;comment:发送者用户UUID"`
-	ToId       string `gorm:"type:varchar(64);index;not null;comment:接收者UUID，单聊为用户UUID，群聊为群UUID"`
-	Type       int    `gorm:"type:tinyint;default:1;comment:消息类型 1:单聊 2:群聊"`
-	MediaType  int    `gorm:"type:tinyint;default:1;comment:消息内容类型 1:文本 2:图片 3:语音"`
-	Content    string `gorm:"type:text;comment:消息内容"`
-
-	PicUrl string `gorm:"type:varchar(255);default:''"`
-	Url    string `gorm:"type:varchar(255);default:''"`
+	Uuid string `gorm:"type:varchar(64);uniqueIndex;not null;comment:消息唯一标识"`
+	//客户端生成的消息ID，同一发送者内唯一，用于重发去重；老数据为NULL不参与唯一约束
+	ClientMsgId *string `gorm:"type:varchar(64);uniqueIndex:idx_sender_client_msg;comment:客户端消息ID"`
+	FromUserId  string  `gorm:"type:varchar(64);index;uniqueIndex:idx_sender_client_msg;not null;comment:发送者用户UUID"`
+	ToId        string  `gorm:"type:varchar(64);index;not null;comment:接收者UUID，单聊为用户UUID，群聊为群UUID"`
+	Type        int     `gorm:"type:tinyint;default:1;comment:消息类型 1:单聊 2:群聊"`
+	ConvId      string  `gorm:"type:varchar(140);index:idx_conv_seq;default:'';comment:会话ID"`
+	Seq         int64   `gorm:"index:idx_conv_seq;index:idx_root_seq;default:0;comment:会话内递增序号"`
+	MediaType   int     `gorm:"type:tinyint;default:1;comment:消息内容类型 1:文本 2:图片 3:语音 4:视频 5:文件 6:位置"`
+	Content     string  `gorm:"type:mediumtext;comment:消息内容，合并转发的聊天记录可能超过64KB"`
+	Status      int     `gorm:"type:tinyint;default:0;comment:消息状态 0:正常 1:已撤回"`
+	RecallBy    string  `gorm:"type:varchar(64);default:'';comment:撤回操作人UUID"`
+	EditedAt    int64   `gorm:"default:0;comment:最后编辑时间，0为未编辑"`
+
+	ReplyTo      string `gorm:"type:varchar(64);index;default:'';comment:引用回复的消息UUID"`
+	RootId       string `gorm:"type:varchar(64);index:idx_root_seq;default:'';comment:所属话题的根消息UUID，仅群聊"`
+	ThreadCount  int    `gorm:"default:0;comment:话题回复数，仅根消息有值"`
+	ThreadLastAt int64  `gorm:"default:0;comment:话题最后回复时间，仅根消息有值"`
+
+	MentionIds string `gorm:"type:text;comment:被@的用户UUID列表JSON"`
+	MentionAll bool   `gorm:"default:false;comment:是否@所有人"`
+	Forwarded  bool   `gorm:"default:false;comment:是否是转发的消息"`
+	ExpireAt   int64  `gorm:"index;default:0;comment:阅后即焚的过期时间戳，0为不过期"`
+
+	PicUrl string `gorm:"type:varchar(255);default:''"` //缩略图
+	Url    string `gorm:"type:varchar(255);default:''"` //媒体文件地址
+	Extra  string `gorm:"type:mediumtext;comment:富媒体附加信息JSON"`
+	FileId string `gorm:"type:varchar(64);index;default:'';comment:引用的附件ID，下载鉴权用"`
 }
 
 func (Message) TableName() string {
 	return "messages"
 }
+
+// 会话ID：单聊为排序后的双方ID，群聊为群ID
+func ConversationId(chatType int, fromId, toId string) string {
+	if chatType == MsgTypeGroup {
+		return toId
+	}
+	if fromId > toId {
+		fromId, toId = toId, fromId
+	}
+	return fromId + "_" + toId
+}
@@ -0,0 +1,16 @@
+package model
+
+import "gorm.io/gorm"
+
+// 消息编辑前的内容，每编辑一次记一条
+type MessageRevision struct {
+	gorm.Model
+	MsgUuid  string `gorm:"type:varchar(64);uniqueIndex:idx_msg_revision;not null;comment:原消息UUID"`
+	Revision int    `gorm:"uniqueIndex:idx_msg_revision;not null;comment:版本号，从1开始"`
+	Content  string `gorm:"type:text;comment:被替换掉的内容"`
+	EditorId string `gorm:"type:varchar(64);not null;comment:编辑人UUID"`
+}
+
+func (MessageRevision) TableName() string {
+	return "message_revisions"
+}
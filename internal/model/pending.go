@@ -0,0 +1,9 @@
+package model
+
+// 待确认投递的消息，存redis，收到客户端ACK后删除
+// 消息内容按消息ID只存一份，每个接收者只记重传次数
+type PendingDelivery struct {
+	MsgId    string `json:"msg_id"`
+	Payload  string `json:"payload"`  //推给客户端的原始数据
+	Attempts int    `json:"attempts"` //已重传次数
+}
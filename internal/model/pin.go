@@ -0,0 +1,15 @@
+package model
+
+import "gorm.io/gorm"
+
+// 会话里置顶的消息
+type PinnedMessage struct {
+	gorm.Model
+	ConvId   string `gorm:"type:varchar(140);uniqueIndex:idx_conv_msg;not null;comment:会话ID"`
+	MsgUuid  string `gorm:"type:varchar(64);uniqueIndex:idx_conv_msg;not null;comment:消息UUID"`
+	PinnedBy string `gorm:"type:varchar(64);not null;comment:置顶操作人UUID"`
+}
+
+func (PinnedMessage) TableName() string {
+	return "pinned_messages"
+}
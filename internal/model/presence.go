@@ -0,0 +1,8 @@
+package model
+
+// 在线状态，存redis
+type Presence struct {
+	UserId   string `json:"user_id"`
+	Online   bool   `json:"online"`
+	LastSeen int64  `json:"last_seen"` //最后活跃时间戳，在线时为最近一次心跳
+}
@@ -0,0 +1,34 @@
+package model
+
+// 引用预览里正文最多保留的字数
+const quotePreviewLen = 60
+
+// 被引用消息的精简预览，回复消息推送和拉取历史时带上
+type QuoteInfo struct {
+	MsgId      string `json:"msg_id"`
+	FromUserId string `json:"from_user_id"`
+	MediaType  int    `json:"media_type"`
+	Content    string `json:"content"` //非文本消息为占位文字，过长会截断
+	Recalled   bool   `json:"recalled,omitempty"`
+}
+
+func NewQuoteInfo(msg *Message) *QuoteInfo {
+	quote := &QuoteInfo{
+		MsgId:      msg.Uuid,
+		FromUserId: msg.FromUserId,
+		MediaType:  msg.MediaType,
+	}
+	//原消息撤回后引用处也不再展示内容
+	if msg.Status == MsgStatusRecalled {
+		quote.MediaType = MediaTypeText
+		quote.Content = RecalledPlaceholder
+		quote.Recalled = true
+		return quote
+	}
+	content := []rune(MessagePreview(msg.MediaType, msg.Content))
+	if len(content) > quotePreviewLen {
+		content = append(content[:quotePreviewLen], []rune("...")...)
+	}
+	quote.Content = string(content)
+	return quote
+}
@@ -0,0 +1,45 @@
+package model
+
+import "gorm.io/gorm"
+
+// 表情回应，同一个人对同一条消息的同一个表情只记一次
+type MessageReaction struct {
+	gorm.Model
+	MsgUuid string `gorm:"type:varchar(64);uniqueIndex:idx_msg_user_emoji;not null;comment:消息UUID"`
+	UserId  string `gorm:"type:varchar(64);uniqueIndex:idx_msg_user_emoji;not null;comment:回应人UUID"`
+	Emoji   string `gorm:"type:varchar(32);uniqueIndex:idx_msg_user_emoji;not null;comment:表情"`
+}
+
+func (MessageReaction) TableName() string {
+	return "message_reactions"
+}
+
+// 按表情聚合后的回应，随消息返回给客户端
+type ReactionSummary struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIds []string `json:"user_ids"` //回应过的人，按回应时间排序
+}
+
+// 按消息聚合回应，表情按第一次出现的先后排序；reactions需按回应时间升序
+func AggregateReactions(reactions []*MessageReaction) map[string][]ReactionSummary {
+	result := make(map[string][]ReactionSummary)
+	for _, reaction := range reactions {
+		summaries := result[reaction.MsgUuid]
+		idx := -1
+		for i := range summaries {
+			if summaries[i].Emoji == reaction.Emoji {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			summaries = append(summaries, ReactionSummary{Emoji: reaction.Emoji})
+			idx = len(summaries) - 1
+		}
+		summaries[idx].Count++
+		summaries[idx].UserIds = append(summaries[idx].UserIds, reaction.UserId)
+		result[reaction.MsgUuid] = summaries
+	}
+	return result
+}
@@ -0,0 +1,65 @@
+package model
+
+import "encoding/json"
+
+// 合并转发的聊天记录，整份快照序列化后存在messages.extra里
+type ChatRecord struct {
+	Title   string           `json:"title"`
+	Count   int              `json:"count"`
+	Preview []string         `json:"preview,omitempty"` //会话里展示的前几行摘要
+	Items   []ChatRecordItem `json:"items,omitempty"`   //完整快照，推送和历史里不带，通过展开接口获取
+}
+
+// 聊天记录里的一条消息，转发时的快照，之后原消息撤回或编辑都不影响
+type ChatRecordItem struct {
+	MsgId      string     `json:"msg_id"`
+	FromUserId string     `json:"from_user_id"`
+	MediaType  int        `json:"media_type"`
+	Content    string     `json:"content"`
+	Media      *MediaInfo `json:"media,omitempty"`
+	CreatedAt  string     `json:"created_at"`
+}
+
+// 摘要最多展示几行
+const recordPreviewLines = 4
+
+func NewChatRecord(title string, messages []*Message) *ChatRecord {
+	record := &ChatRecord{Title: title, Count: len(messages)}
+	for _, msg := range messages {
+		item := ChatRecordItem{
+			MsgId:      msg.Uuid,
+			FromUserId: msg.FromUserId,
+			MediaType:  msg.MediaType,
+			Content:    msg.Content,
+			CreatedAt:  msg.CreatedAt.Format("2006-01-02 15:04:05"),
+		}
+		//嵌套的聊天记录不再展开，只留占位
+		if msg.MediaType == MediaTypeRecord {
+			item.Content = MessagePreview(msg.MediaType, msg.Content)
+		} else {
+			item.Media = msg.MediaInfo()
+		}
+		record.Items = append(record.Items, item)
+		if len(record.Preview) < recordPreviewLines {
+			record.Preview = append(record.Preview, MessagePreview(item.MediaType, item.Content))
+		}
+	}
+	return record
+}
+
+// 去掉完整快照，只留标题和摘要
+func (r *ChatRecord) Summary() *ChatRecord {
+	return &ChatRecord{Title: r.Title, Count: r.Count, Preview: r.Preview}
+}
+
+// 解析消息上的聊天记录快照，不是聊天记录消息返回nil
+func (m *Message) ChatRecord() *ChatRecord {
+	if m.MediaType != MediaTypeRecord || m.Extra == "" {
+		return nil
+	}
+	var record ChatRecord
+	if err := json.Unmarshal([]byte(m.Extra), &record); err != nil {
+		return nil
+	}
+	return &record
+}
@@ -8,6 +8,7 @@ type Session struct {
 	Top       bool   `gorm:"default:false;comment:是否置顶"`
 	Mute      int    `gorm:"type:tinyint;default:0;comment:是否免打扰 0:否 1:是"`
 	UnreadCnt int    `gorm:"default:0;comment:未读消息数"`
+	ReadSeq   int64  `gorm:"default:0;comment:单聊已读到的对方消息seq"`
 	LastMsg   string `gorm:"type:varchar(255);comment:最新消息"`
 	LastTime  int64  `gorm:"index;comment:最新消息时间戳"`
 	UserId    string `gorm:"type:varchar(255);uniqueIndex:idx_user_target;not null;comment:会话所属用户Id"`
@@ -23,6 +24,7 @@ type SessionCache struct {
 	LastTime  int64  `json:"last_time"`
 	LastMsg   string `json:"last_msg"`
 	UnreadCnt int    `json:"unread_cnt"`
+	Mute      bool   `json:"mute"`
 }
 
 func (Session) TableName() string {
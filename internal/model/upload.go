@@ -0,0 +1,13 @@
+package model
+
+// 分片上传会话，存redis，合并完成或过期后删除
+type UploadSession struct {
+	UploadId   string `json:"upload_id"`
+	OwnerId    string `json:"owner_id"`
+	FileName   string `json:"file_name"`
+	Size       int64  `json:"size"`        //文件总大小
+	ChunkSize  int64  `json:"chunk_size"`  //除最后一片外每片的大小
+	ChunkCount int    `json:"chunk_count"` //总片数
+	Sha256     string `json:"sha256"`      //整个文件的校验和，合并后核对
+	CreatedAt  int64  `json:"created_at"`
+}
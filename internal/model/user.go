@@ -4,6 +4,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// 用户状态
+const (
+	UserStatusNormal   = 1 //正常
+	UserStatusDisabled = 2 //禁用
+)
+
 type User struct {
 	gorm.Model
 	Uuid      string `gorm:"type:varchar(64);uniqueIndex;not null;comment:用户标识"`
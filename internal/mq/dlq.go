@@ -0,0 +1,124 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// 死信消息头
+const (
+	HeaderError           = "x-error"
+	HeaderSourcePartition = "x-source-partition"
+	HeaderSourceOffset    = "x-source-offset"
+	HeaderFailedAt        = "x-failed-at"
+)
+
+// 查看死信时单次读取的等待时间，读到头就返回
+const deadLetterReadTimeout = 2 * time.Second
+
+// DeadLetter 死信队列里的一条消息
+type DeadLetter struct {
+	Partition       int    `json:"partition"`
+	Offset          int64  `json:"offset"`
+	Key             string `json:"key"`
+	Value           string `json:"value"`
+	Error           string `json:"error"`
+	SourcePartition int    `json:"source_partition"`
+	SourceOffset    int64  `json:"source_offset"`
+	FailedAt        int64  `json:"failed_at"`
+}
+
+// PublishDeadLetter 把处理失败的消息原样写入死信topic，附带失败原因和来源位置
+func (k *KafkaClient) PublishDeadLetter(ctx context.Context, m kafka.Message, cause error) error {
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	return k.DLQWriter.WriteMessages(ctx, kafka.Message{
+		Key:   m.Key,
+		Value: m.Value,
+		Headers: []kafka.Header{
+			{Key: HeaderError, Value: []byte(reason)},
+			{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(m.Partition))},
+			{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+			{Key: HeaderFailedAt, Value: []byte(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	})
+}
+
+// ReadDeadLetters 从指定分区的offset开始读最多limit条死信
+func (k *KafkaClient) ReadDeadLetters(ctx context.Context, partition int, offset int64, limit int) ([]*DeadLetter, error) {
+	reader := k.newDeadLetterReader(partition)
+	defer reader.Close()
+	if err := reader.SetOffset(offset); err != nil {
+		return nil, err
+	}
+	var result []*DeadLetter
+	for len(result) < limit {
+		readCtx, cancel := context.WithTimeout(ctx, deadLetterReadTimeout)
+		m, err := reader.ReadMessage(readCtx)
+		cancel()
+		if err != nil {
+			//等不到新消息说明已经读到头了
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+		result = append(result, toDeadLetter(m))
+	}
+	return result, nil
+}
+
+// ReplayDeadLetter 把一条死信按原来的key重新投递到业务topic
+func (k *KafkaClient) ReplayDeadLetter(ctx context.Context, partition int, offset int64) (*DeadLetter, error) {
+	letters, err := k.ReadDeadLetters(ctx, partition, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(letters) == 0 || letters[0].Offset != offset {
+		return nil, ErrDeadLetterNotFound
+	}
+	letter := letters[0]
+	if err := k.Publish(ctx, []byte(letter.Key), []byte(letter.Value)); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+func (k *KafkaClient) newDeadLetterReader(partition int) *kafka.Reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{k.addr},
+		Topic:     k.DLQWriter.Topic,
+		Partition: partition,
+		MaxBytes:  10e6, // 10MB
+	})
+}
+
+func toDeadLetter(m kafka.Message) *DeadLetter {
+	letter := &DeadLetter{
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       string(m.Key),
+		Value:     string(m.Value),
+	}
+	for _, h := range m.Headers {
+		switch h.Key {
+		case HeaderError:
+			letter.Error = string(h.Value)
+		case HeaderSourcePartition:
+			letter.SourcePartition, _ = strconv.Atoi(string(h.Value))
+		case HeaderSourceOffset:
+			letter.SourceOffset, _ = strconv.ParseInt(string(h.Value), 10, 64)
+		case HeaderFailedAt:
+			letter.FailedAt, _ = strconv.ParseInt(string(h.Value), 10, 64)
+		}
+	}
+	return letter
+}
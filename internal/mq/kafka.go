@@ -8,18 +8,29 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
+// Queue 聊天消息队列，ClientManager只依赖这个接口
+type Queue interface {
+	Publish(ctx context.Context, key, value []byte) error
+	PublishDeadLetter(ctx context.Context, m kafka.Message, cause error) error
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
 type KafkaClient struct {
-	Writer *kafka.Writer
-	Reader *kafka.Reader
+	Writer    *kafka.Writer
+	Reader    *kafka.Reader
+	DLQWriter *kafka.Writer //处理失败的消息写到死信topic
+	addr      string
 }
 
 func NewKafkaClient(cfg *config.KafkaConfig) *KafkaClient {
 	writer := &kafka.Writer{
 		Addr:         kafka.TCP(cfg.Addr),
 		Topic:        cfg.Topic,
-		Balancer:     &kafka.LeastBytes{},
+		Balancer:     &kafka.Hash{}, //按会话ID做key，同一会话固定落在同一分区，保证顺序
 		BatchTimeout: 10 * time.Millisecond,
 	}
+	//消费端用FetchMessage+CommitMessages手动提交，处理完才提交offset
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  []string{cfg.Addr},
 		Topic:    cfg.Topic,
@@ -27,17 +38,40 @@ func NewKafkaClient(cfg *config.KafkaConfig) *KafkaClient {
 		MinBytes: 10e3, // 10KB
 		MaxBytes: 10e6, // 10MB
 	})
+	dlqTopic := cfg.DLQTopic
+	if dlqTopic == "" {
+		dlqTopic = cfg.Topic + ".dlq"
+	}
+	dlqWriter := &kafka.Writer{
+		Addr:     kafka.TCP(cfg.Addr),
+		Topic:    dlqTopic,
+		Balancer: &kafka.Hash{},
+	}
 	return &KafkaClient{
-		Writer: writer,
-		Reader: reader,
+		Writer:    writer,
+		Reader:    reader,
+		DLQWriter: dlqWriter,
+		addr:      cfg.Addr,
 	}
 }
+
+// Publish key为空时由Hash balancer随机分区，需要保序的消息必须带key
 func (k *KafkaClient) Publish(ctx context.Context, key, value []byte) error {
 	return k.Writer.WriteMessages(ctx, kafka.Message{
 		Key:   key,
 		Value: value,
 	})
 }
+
+// FetchMessage 拉取一条消息，不自动提交offset
+func (k *KafkaClient) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return k.Reader.FetchMessage(ctx)
+}
+
+func (k *KafkaClient) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return k.Reader.CommitMessages(ctx, msgs...)
+}
+
 func (k *KafkaClient) Close() {
 	if k.Writer != nil {
 		_ = k.Writer.Close()
@@ -45,4 +79,7 @@ func (k *KafkaClient) Close() {
 	if k.Reader != nil {
 		_ = k.Reader.Close()
 	}
+	if k.DLQWriter != nil {
+		_ = k.DLQWriter.Close()
+	}
 }
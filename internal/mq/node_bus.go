@@ -0,0 +1,54 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bus 网关节点之间的投递通道，ClientManager只依赖这个接口，测试时可以换成内存实现
+type Bus interface {
+	Publish(ctx context.Context, nodeId string, payload []byte) error
+	Subscribe(ctx context.Context, nodeId string) <-chan []byte
+}
+
+// NodeBus 基于redis pub/sub的Bus实现，每个节点订阅自己的频道
+type NodeBus struct {
+	rdb *redis.Client
+}
+
+func NewNodeBus(rdb *redis.Client) *NodeBus {
+	return &NodeBus{rdb: rdb}
+}
+
+func nodeChannel(nodeId string) string {
+	return fmt.Sprintf("im:node:%s", nodeId)
+}
+
+func (b *NodeBus) Publish(ctx context.Context, nodeId string, payload []byte) error {
+	return b.rdb.Publish(ctx, nodeChannel(nodeId), payload).Err()
+}
+
+// Subscribe 订阅发给本节点的消息，ctx取消后关闭
+func (b *NodeBus) Subscribe(ctx context.Context, nodeId string) <-chan []byte {
+	pubsub := b.rdb.Subscribe(ctx, nodeChannel(nodeId))
+	out := make(chan []byte, 256)
+	go func() {
+		defer close(out)
+		defer func() { _ = pubsub.Close() }()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				out <- []byte(msg.Payload)
+			}
+		}
+	}()
+	return out
+}
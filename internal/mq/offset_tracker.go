@@ -0,0 +1,56 @@
+package mq
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// OffsetTracker 记录每个分区已拉取但还没处理完的消息
+// 同一分区里的消息会被不同worker并发处理，完成顺序和offset顺序不一致，
+// 只有前面的消息都处理完了才能提交，否则重启后会漏掉没处理完的那条
+type OffsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	inflight []kafka.Message //按offset递增
+	done     map[int64]bool
+}
+
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// Track 拉到消息后立刻登记，必须按拉取顺序调用
+func (t *OffsetTracker) Track(m kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[m.Partition]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[m.Partition] = p
+	}
+	p.inflight = append(p.inflight, m)
+}
+
+// Done 标记处理完成，返回这个分区现在可以提交到的消息，没有可提交的返回false
+func (t *OffsetTracker) Done(m kafka.Message) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[m.Partition]
+	if !ok {
+		return kafka.Message{}, false
+	}
+	p.done[m.Offset] = true
+	var commit kafka.Message
+	committable := false
+	for len(p.inflight) > 0 && p.done[p.inflight[0].Offset] {
+		commit = p.inflight[0]
+		committable = true
+		delete(p.done, commit.Offset)
+		p.inflight = p.inflight[1:]
+	}
+	return commit, committable
+}
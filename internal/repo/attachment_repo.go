@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"my-chat/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type AttachmentRepository interface {
+	Create(attachment *model.Attachment) error
+	FindByUuid(uuid string) (*model.Attachment, error)
+	FindByOwnerHash(ownerId, hash string) (*model.Attachment, error)
+}
+type attachmentRepository struct {
+	db *gorm.DB
+}
+
+func NewAttachmentRepository(db *gorm.DB) AttachmentRepository {
+	return &attachmentRepository{db: db}
+}
+
+func (r *attachmentRepository) Create(attachment *model.Attachment) error {
+	return r.db.Create(attachment).Error
+}
+
+func (r *attachmentRepository) FindByOwnerHash(ownerId, hash string) (*model.Attachment, error) {
+	var attachment model.Attachment
+	err := r.db.Where("owner_id = ? AND hash = ?", ownerId, hash).First(&attachment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (r *attachmentRepository) FindByUuid(uuid string) (*model.Attachment, error) {
+	var attachment model.Attachment
+	err := r.db.Where("uuid = ?", uuid).First(&attachment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
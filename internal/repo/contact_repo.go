@@ -15,6 +15,8 @@ type ContactRepository interface {
 	UpdateApplyStatus(applyId uint, status string) error
 	DeleteFriend(ownerId, targetId string) error
 	UpdateContactType(ownerId, targetId string, typeInt int) error
+	FindContact(ownerId, targetId string) (*model.Contact, error)
+	FindContactsOf(ownerIds []string, targetId string) ([]*model.Contact, error)
 }
 
 type contactRepository struct {
@@ -85,6 +87,25 @@ func (c *contactRepository) AddFriend(applyId uint, c1, c2 *model.Contact) error
 	})
 }
 
+func (c *contactRepository) FindContact(ownerId, targetId string) (*model.Contact, error) {
+	var contact model.Contact
+	err := c.db.Where("owner_id = ? AND target_id = ?", ownerId, targetId).First(&contact).Error
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// 一批用户各自联系人列表里的targetId，用于反查对方是不是把我当好友
+func (c *contactRepository) FindContactsOf(ownerIds []string, targetId string) ([]*model.Contact, error) {
+	var list []*model.Contact
+	if len(ownerIds) == 0 {
+		return list, nil
+	}
+	err := c.db.Where("owner_id IN ? AND target_id = ?", ownerIds, targetId).Find(&list).Error
+	return list, err
+}
+
 func NewContactRepository(db *gorm.DB) ContactRepository {
 	return &contactRepository{db: db}
 }
@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"my-chat/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 每条消息发送都要查一次，缓存一段时间
+const convTTLCacheExpire = time.Hour
+
+type ConvSettingRepository interface {
+	GetMessageTTL(convId string) (int64, error)
+	SetMessageTTL(convId string, chatType int, ttl int64, operatorId string) error
+}
+type convSettingRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewConvSettingRepository(db *gorm.DB, rdb *redis.Client) ConvSettingRepository {
+	return &convSettingRepository{db: db, rdb: rdb}
+}
+
+func convTTLKey(convId string) string {
+	return fmt.Sprintf("im:conv:ttl:%s", convId)
+}
+
+// 没有设置过返回0
+func (r *convSettingRepository) GetMessageTTL(convId string) (int64, error) {
+	ctx := context.Background()
+	if val, err := r.rdb.Get(ctx, convTTLKey(convId)).Result(); err == nil {
+		if ttl, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return ttl, nil
+		}
+	}
+	var setting model.ConversationSetting
+	err := r.db.Select("message_ttl").Where("conv_id = ?", convId).First(&setting).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	_ = r.rdb.Set(ctx, convTTLKey(convId), setting.MessageTTL, convTTLCacheExpire).Err()
+	return setting.MessageTTL, nil
+}
+
+func (r *convSettingRepository) SetMessageTTL(convId string, chatType int, ttl int64, operatorId string) error {
+	err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conv_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"message_ttl", "updated_by", "updated_at"}),
+	}).Create(&model.ConversationSetting{
+		ConvId:     convId,
+		Type:       chatType,
+		MessageTTL: ttl,
+		UpdatedBy:  operatorId,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return r.rdb.Del(context.Background(), convTTLKey(convId)).Err()
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"my-chat/internal/model"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type DedupRepository interface {
+	Reserve(senderId, clientMsgId string, record *model.SendRecord, window time.Duration) (*model.SendRecord, bool, error)
+	Release(senderId, clientMsgId string) error
+}
+type dedupRepository struct {
+	rdb *redis.Client
+}
+
+func NewDedupRepository(rdb *redis.Client) DedupRepository {
+	return &dedupRepository{rdb: rdb}
+}
+
+func dedupKey(senderId, clientMsgId string) string {
+	return fmt.Sprintf("im:dedup:%s:%s", senderId, clientMsgId)
+}
+
+// Reserve SETNX占位，成功返回true；已经存在返回false和第一次分配的记录
+func (r *dedupRepository) Reserve(senderId, clientMsgId string, record *model.SendRecord, window time.Duration) (*model.SendRecord, bool, error) {
+	ctx := context.Background()
+	key := dedupKey(senderId, clientMsgId)
+	dataBytes, _ := json.Marshal(record)
+	ok, err := r.rdb.SetNX(ctx, key, string(dataBytes), window).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		return record, true, nil
+	}
+	val, err := r.rdb.Get(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	var existing model.SendRecord
+	if err := json.Unmarshal([]byte(val), &existing); err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+// Release 消息没能投递出去时释放占位，允许客户端重发
+func (r *dedupRepository) Release(senderId, clientMsgId string) error {
+	return r.rdb.Del(context.Background(), dedupKey(senderId, clientMsgId)).Err()
+}
@@ -20,6 +20,9 @@ type GroupRepository interface {
 	FindGroup(groupId string) (*model.Group, error)
 	FindGroupsByIds(groupIds []string) (map[string]*model.Group, error)
 	IsMember(groupId, userId string) (bool, error)
+	GetMember(groupId, userId string) (*model.GroupMember, error)
+	UpdateReadSeq(groupId, userId string, seq int64) error
+	GetReadSeqs(userId string) (map[string]int64, error)
 	GetGroupMembers(groupId string) ([]*model.GroupMember, error)
 	GetUserJoinedGroups(userId string) ([]*model.Group, error)
 	RemoveMember(groupId, userId string) error
@@ -139,6 +142,38 @@ func (r *groupRepository) IsMember(groupId, userId string) (bool, error) {
 	return count > 0, err
 }
 
+func (r *groupRepository) GetMember(groupId, userId string) (*model.GroupMember, error) {
+	var member model.GroupMember
+	err := r.db.Where("group_id = ? AND user_id = ?", groupId, userId).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// 推进成员的已读位置，只能往前走
+func (r *groupRepository) UpdateReadSeq(groupId, userId string, seq int64) error {
+	return r.db.Model(&model.GroupMember{}).
+		Where("group_id = ? AND user_id = ? AND read_seq < ?", groupId, userId, seq).
+		Update("read_seq", seq).Error
+}
+
+// 用户在所有群里的已读位置 groupId -> readSeq
+func (r *groupRepository) GetReadSeqs(userId string) (map[string]int64, error) {
+	var members []*model.GroupMember
+	err := r.db.Select("group_id", "read_seq").
+		Where("user_id = ?", userId).
+		Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(members))
+	for _, member := range members {
+		result[member.GroupId] = member.ReadSeq
+	}
+	return result, nil
+}
+
 func NewGroupRepository(db *gorm.DB, rdb *redis.Client) GroupRepository {
 	return &groupRepository{
 		db:  db,
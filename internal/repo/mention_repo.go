@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"my-chat/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MentionRepository interface {
+	ListUnread(userId string, limit int) ([]*model.Mention, error)
+	UnreadGroupIds(userId string) (map[string]bool, error)
+}
+type mentionRepository struct {
+	db *gorm.DB
+}
+
+func NewMentionRepository(db *gorm.DB) MentionRepository {
+	return &mentionRepository{db: db}
+}
+
+// 未读的@：所在群里seq大于自己已读seq、消息没撤回、不是自己发的
+func (r *mentionRepository) unreadQuery(userId string) *gorm.DB {
+	return r.db.Table("group_mentions AS m").
+		Joins("JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = ? AND gm.deleted_at IS NULL", userId).
+		Joins("JOIN messages msg ON msg.uuid = m.msg_uuid AND msg.status = ?", model.MsgStatusNormal).
+		Where("(m.user_id = ? OR m.user_id = '') AND m.from_user_id <> ?", userId, userId).
+		Where("m.seq > gm.read_seq AND m.deleted_at IS NULL").
+		Where("(msg.expire_at = 0 OR msg.expire_at > ?)", time.Now().Unix())
+}
+
+func (r *mentionRepository) ListUnread(userId string, limit int) ([]*model.Mention, error) {
+	var mentions []*model.Mention
+	err := r.unreadQuery(userId).
+		Select("m.*").
+		Order("m.id DESC").
+		Limit(limit).
+		Find(&mentions).Error
+	return mentions, err
+}
+
+// 有未读@的群，用于会话列表打标记
+func (r *mentionRepository) UnreadGroupIds(userId string) (map[string]bool, error) {
+	var groupIds []string
+	err := r.unreadQuery(userId).
+		Distinct("m.group_id").
+		Pluck("m.group_id", &groupIds).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(groupIds))
+	for _, groupId := range groupIds {
+		result[groupId] = true
+	}
+	return result, nil
+}
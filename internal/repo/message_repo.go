@@ -2,14 +2,29 @@ package repo
 
 import (
 	"my-chat/internal/model"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessageRepository interface {
 	CreateMessage(message *model.Message) error
 	GetMessages(userId, targetId string, chatType int, offset, limit int) ([]*model.Message, error)
 	BatchCreate(messages []*model.Message) error
+	FindByUuid(uuid string) (*model.Message, error)
+	FindByClientMsgId(fromUserId, clientMsgId string) (*model.Message, error)
+	FindExisting(messages []*model.Message) ([]*model.Message, error)
+	HasVisibleFileRef(fileId, userId string) (bool, error)
+	RecallMessage(uuid, operatorId string) error
+	EditMessage(uuid, newContent, editorId string, editedAt int64) error
+	GetRevisions(uuid string) ([]*model.MessageRevision, error)
+	GetMessagesAfterSeq(convId string, seq int64, limit int) ([]*model.Message, error)
+	GetThreadMessages(rootId string, seq int64, limit int) ([]*model.Message, error)
+	FindByUuids(uuids []string) ([]*model.Message, error)
+	FindExpired(now int64, limit int) ([]*model.Message, error)
+	PurgeMessages(uuids []string) ([]string, error)
+	GetMaxSeq(convId string) (int64, error)
 }
 type messageRepository struct {
 	db *gorm.DB
@@ -19,14 +34,205 @@ func (r *messageRepository) BatchCreate(messages []*model.Message) error {
 	if len(messages) == 0 {
 		return nil
 	}
-	return r.db.Create(messages).Error
+	//整批放在一个事务里，要么全成功要么全失败
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(messages, len(messages)).Error; err != nil {
+			return err
+		}
+		if err := incrThreadStats(tx, messages); err != nil {
+			return err
+		}
+		if err := saveMentions(tx, messages); err != nil {
+			return err
+		}
+		return saveFileRefs(tx, messages)
+	})
+}
+
+// 聊天记录引用的附件和消息同一个事务写入，下载鉴权靠它找到能看到附件的人
+func saveFileRefs(tx *gorm.DB, messages []*model.Message) error {
+	var refs []*model.MessageFileRef
+	for _, message := range messages {
+		refs = append(refs, message.FileRefs()...)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(refs, len(refs)).Error
+}
+
+// @记录和消息同一个事务写入
+func saveMentions(tx *gorm.DB, messages []*model.Message) error {
+	var mentions []*model.Mention
+	for _, message := range messages {
+		mentions = append(mentions, message.Mentions()...)
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(mentions, len(mentions)).Error
+}
+
+// 话题回复落库时同步更新根消息上的回复数和最后回复时间，和插入在同一个事务里
+func incrThreadStats(tx *gorm.DB, messages []*model.Message) error {
+	type threadStat struct {
+		count  int
+		lastAt int64
+	}
+	stats := make(map[string]*threadStat)
+	for _, message := range messages {
+		if message.RootId == "" {
+			continue
+		}
+		stat, ok := stats[message.RootId]
+		if !ok {
+			stat = &threadStat{}
+			stats[message.RootId] = stat
+		}
+		stat.count++
+		if ts := message.CreatedAt.Unix(); ts > stat.lastAt {
+			stat.lastAt = ts
+		}
+	}
+	for rootId, stat := range stats {
+		err := tx.Model(&model.Message{}).
+			Where("uuid = ?", rootId).
+			Updates(map[string]interface{}{
+				"thread_count":   gorm.Expr("thread_count + ?", stat.count),
+				"thread_last_at": gorm.Expr("GREATEST(thread_last_at, ?)", stat.lastAt),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 一次查出这批消息里已经落过库的：uuid相同，或者同一发送者的client_msg_id相同
+func (r *messageRepository) FindExisting(messages []*model.Message) ([]*model.Message, error) {
+	var existing []*model.Message
+	var uuids []string
+	var clientKeys [][]interface{}
+	for _, message := range messages {
+		uuids = append(uuids, message.Uuid)
+		if message.ClientMsgId != nil && *message.ClientMsgId != "" {
+			clientKeys = append(clientKeys, []interface{}{message.FromUserId, *message.ClientMsgId})
+		}
+	}
+	if len(uuids) == 0 {
+		return existing, nil
+	}
+	db := r.db.Select("uuid", "from_user_id", "client_msg_id").Where("uuid IN ?", uuids)
+	if len(clientKeys) > 0 {
+		db = db.Or("(from_user_id, client_msg_id) IN ?", clientKeys)
+	}
+	err := db.Find(&existing).Error
+	return existing, err
+}
+
+func (r *messageRepository) FindByClientMsgId(fromUserId, clientMsgId string) (*model.Message, error) {
+	var message model.Message
+	err := r.db.Where("from_user_id = ? AND client_msg_id = ?", fromUserId, clientMsgId).First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// 是否存在引用了这个附件、且用户能看到的消息：私聊是收发双方，群聊是群成员；已撤回、已过期的不算
+// 聊天记录消息通过message_file_refs引用附件
+func (r *messageRepository) HasVisibleFileRef(fileId, userId string) (bool, error) {
+	sub := notExpired(r.db.Table("messages AS m").
+		Select("1").
+		Joins("LEFT JOIN group_members gm ON m.type = ? AND gm.group_id = m.to_id AND gm.user_id = ? AND gm.deleted_at IS NULL",
+			model.MsgTypeGroup, userId).
+		Where("(m.file_id = ? OR m.uuid IN (SELECT msg_uuid FROM message_file_refs WHERE file_id = ?))", fileId, fileId).
+		Where("m.status = ? AND m.deleted_at IS NULL", model.MsgStatusNormal).
+		Where("((m.type = ? AND (m.from_user_id = ? OR m.to_id = ?)) OR gm.id IS NOT NULL)",
+			model.MsgTypeSingle, userId, userId))
+	var exists bool
+	err := r.db.Raw("SELECT EXISTS(?)", sub).Scan(&exists).Error
+	return exists, err
+}
+
+func (r *messageRepository) FindByUuid(uuid string) (*model.Message, error) {
+	var message model.Message
+	err := r.db.Where("uuid = ?", uuid).First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// 撤回消息，只改状态不删内容，方便审计
+func (r *messageRepository) RecallMessage(uuid, operatorId string) error {
+	return r.db.Model(&model.Message{}).
+		Where("uuid = ? AND status = ?", uuid, model.MsgStatusNormal).
+		Updates(map[string]interface{}{
+			"status":    model.MsgStatusRecalled,
+			"recall_by": operatorId,
+		}).Error
+}
+
+// 编辑消息：旧内容存为一个版本，再替换正文，放在一个事务里
+// 先锁住消息行，并发编辑排队执行，旧内容和版本号都以锁住时的为准
+func (r *messageRepository) EditMessage(uuid, newContent, editorId string, editedAt int64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var message model.Message
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("uuid = ? AND status = ?", uuid, model.MsgStatusNormal).
+			First(&message).Error
+		//并发撤回了
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&model.MessageRevision{}).Where("msg_uuid = ?", uuid).Count(&count).Error; err != nil {
+			return err
+		}
+		revision := &model.MessageRevision{
+			MsgUuid:  uuid,
+			Revision: int(count) + 1,
+			Content:  message.Content,
+			EditorId: editorId,
+		}
+		if err := tx.Create(revision).Error; err != nil {
+			return err
+		}
+		return tx.Model(&message).
+			Updates(map[string]interface{}{
+				"content":   newContent,
+				"edited_at": editedAt,
+			}).Error
+	})
+}
+
+func (r *messageRepository) GetRevisions(uuid string) ([]*model.MessageRevision, error) {
+	var revisions []*model.MessageRevision
+	err := r.db.Where("msg_uuid = ?", uuid).Order("revision asc").Find(&revisions).Error
+	return revisions, err
 }
 
 func NewMessageRepository(db *gorm.DB) MessageRepository {
 	return &messageRepository{db: db}
 }
 func (r *messageRepository) CreateMessage(message *model.Message) error {
-	return r.db.Create(message).Error
+	if message.RootId == "" && message.MentionIds == "" && !message.MentionAll && message.MediaType != model.MediaTypeRecord {
+		return r.db.Create(message).Error
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		messages := []*model.Message{message}
+		if err := incrThreadStats(tx, messages); err != nil {
+			return err
+		}
+		if err := saveMentions(tx, messages); err != nil {
+			return err
+		}
+		return saveFileRefs(tx, messages)
+	})
 }
 func (r *messageRepository) GetMessages(userId, targetId string, chatType int, offset, limit int) ([]*model.Message, error) {
 	var messages []*model.Message
@@ -37,9 +243,94 @@ func (r *messageRepository) GetMessages(userId, targetId string, chatType int, o
 	} else {
 		db = db.Where("type = 2 AND to_id = ?", targetId)
 	}
-	err := db.Order("created_at DESC").
+	//created_at只精确到秒，同一秒内的消息按seq排才不会乱序；seq为0的旧消息按自增id排
+	err := notExpired(db).Order("seq DESC, id DESC").
 		Offset(offset).
 		Limit(limit).
 		Find(&messages).Error
 	return messages, err
 }
+
+// 按seq升序拉取某个会话seq之后的消息，用于断线重连后增量同步
+func (r *messageRepository) GetMessagesAfterSeq(convId string, seq int64, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := notExpired(r.db).Where("conv_id = ? AND seq > ?", convId, seq).
+		Order("seq ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// 按seq升序分页拉取某个话题下的回复
+func (r *messageRepository) GetThreadMessages(rootId string, seq int64, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := notExpired(r.db).Where("root_id = ? AND seq > ?", rootId, seq).
+		Order("seq ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// 批量查消息，用于拼引用预览、转发、置顶和@列表，过期的阅后即焚消息当作不存在
+func (r *messageRepository) FindByUuids(uuids []string) ([]*model.Message, error) {
+	var messages []*model.Message
+	if len(uuids) == 0 {
+		return messages, nil
+	}
+	err := notExpired(r.db.Where("uuid IN ?", uuids)).Find(&messages).Error
+	return messages, err
+}
+
+// 过滤掉已经过期、还没来得及清理的阅后即焚消息
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("(expire_at = 0 OR expire_at > ?)", time.Now().Unix())
+}
+
+// 已经过期的阅后即焚消息，最早过期的在前
+func (r *messageRepository) FindExpired(now int64, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.Where("expire_at > 0 AND expire_at <= ?", now).
+		Order("expire_at ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// 物理删除消息及其附属数据，返回真正删掉的消息ID
+// 多个节点同时清理时逐条删除，只有删成功的节点负责通知客户端
+func (r *messageRepository) PurgeMessages(uuids []string) ([]string, error) {
+	var purged []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		purged = purged[:0]
+		for _, uuid := range uuids {
+			result := tx.Unscoped().Where("uuid = ?", uuid).Delete(&model.Message{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				purged = append(purged, uuid)
+			}
+		}
+		if len(purged) == 0 {
+			return nil
+		}
+		for _, related := range []interface{}{&model.MessageRevision{}, &model.MessageReaction{}, &model.Mention{}, &model.PinnedMessage{}, &model.MessageFileRef{}} {
+			if err := tx.Unscoped().Where("msg_uuid IN ?", purged).Delete(related).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+func (r *messageRepository) GetMaxSeq(convId string) (int64, error) {
+	var maxSeq int64
+	err := r.db.Model(&model.Message{}).
+		Where("conv_id = ?", convId).
+		Select("COALESCE(MAX(seq), 0)").
+		Scan(&maxSeq).Error
+	return maxSeq, err
+}
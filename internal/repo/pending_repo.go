@@ -0,0 +1,174 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"my-chat/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 离线/未确认消息最多保留7天，超过的用户可以通过历史记录拉取
+const pendingExpire = 168 * time.Hour
+
+type PendingRepository interface {
+	AddPending(msgId string, payload []byte, userIds []string, retryAt int64) error
+	UpdatePending(userId, msgId string, attempts int, retryAt int64) (bool, error)
+	RemovePending(userId, msgId string) error
+	DropMessage(msgId string, userIds []string) error
+	GetDuePending(userId string, now int64) ([]*model.PendingDelivery, error)
+	GetAllPending(userId string) ([]*model.PendingDelivery, error)
+}
+type pendingRepository struct {
+	rdb *redis.Client
+}
+
+func NewPendingRepository(rdb *redis.Client) PendingRepository {
+	return &pendingRepository{rdb: rdb}
+}
+
+// 消息内容只存一份，群里所有待确认的成员共用
+func pendingPayloadKey(msgId string) string {
+	return fmt.Sprintf("im:pending:payload:%s", msgId)
+}
+
+// HASH存每个用户待确认的消息ID -> 已重传次数
+func pendingDataKey(userId string) string {
+	return fmt.Sprintf("im:pending:data:%s", userId)
+}
+
+// ZSET存下次重传时间
+func pendingRetryKey(userId string) string {
+	return fmt.Sprintf("im:pending:retry:%s", userId)
+}
+
+// 消息内容写一份，再给每个接收者登记待确认和重传时间
+func (r *pendingRepository) AddPending(msgId string, payload []byte, userIds []string, retryAt int64) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	pipe.Set(ctx, pendingPayloadKey(msgId), payload, pendingExpire)
+	for _, userId := range userIds {
+		pipe.HSet(ctx, pendingDataKey(userId), msgId, 0)
+		pipe.Expire(ctx, pendingDataKey(userId), pendingExpire)
+		pipe.ZAdd(ctx, pendingRetryKey(userId), redis.Z{Score: float64(retryAt), Member: msgId})
+		pipe.Expire(ctx, pendingRetryKey(userId), pendingExpire)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 还在待确认列表里才更新，避免和ACK并发时把已经确认的消息写回去
+// retryAt为0表示不再定时重传，消息仍保留，等下次上线补发
+var updatePendingScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if tonumber(ARGV[3]) > 0 then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+	redis.call('EXPIRE', KEYS[2], ARGV[4])
+else
+	redis.call('ZREM', KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// 更新重传次数和下次重传时间，消息已经被确认返回false
+func (r *pendingRepository) UpdatePending(userId, msgId string, attempts int, retryAt int64) (bool, error) {
+	updated, err := updatePendingScript.Run(context.Background(), r.rdb,
+		[]string{pendingDataKey(userId), pendingRetryKey(userId)},
+		msgId, attempts, retryAt, int64(pendingExpire/time.Second)).Int()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+func (r *pendingRepository) RemovePending(userId, msgId string) error {
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	pipe.HDel(ctx, pendingDataKey(userId), msgId)
+	pipe.ZRem(ctx, pendingRetryKey(userId), msgId)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 消息撤回或者被清理后不能再补发：删掉共用的内容和所有接收者的登记
+func (r *pendingRepository) DropMessage(msgId string, userIds []string) error {
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	pipe.Del(ctx, pendingPayloadKey(msgId))
+	for _, userId := range userIds {
+		pipe.HDel(ctx, pendingDataKey(userId), msgId)
+		pipe.ZRem(ctx, pendingRetryKey(userId), msgId)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 拿到所有到了重传时间的消息
+func (r *pendingRepository) GetDuePending(userId string, now int64) ([]*model.PendingDelivery, error) {
+	ctx := context.Background()
+	msgIds, err := r.rdb.ZRangeByScore(ctx, pendingRetryKey(userId), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now, 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(msgIds) == 0 {
+		return nil, nil
+	}
+	attempts, err := r.rdb.HMGet(ctx, pendingDataKey(userId), msgIds...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.loadPayloads(userId, msgIds, attempts)
+}
+
+func (r *pendingRepository) GetAllPending(userId string) ([]*model.PendingDelivery, error) {
+	values, err := r.rdb.HGetAll(context.Background(), pendingDataKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	msgIds := make([]string, 0, len(values))
+	attempts := make([]interface{}, 0, len(values))
+	for msgId, v := range values {
+		msgIds = append(msgIds, msgId)
+		attempts = append(attempts, v)
+	}
+	return r.loadPayloads(userId, msgIds, attempts)
+}
+
+// 按消息ID取出共用的消息内容，内容已经没了（过期、撤回、阅后即焚清理）的顺手清掉登记
+func (r *pendingRepository) loadPayloads(userId string, msgIds []string, attempts []interface{}) ([]*model.PendingDelivery, error) {
+	if len(msgIds) == 0 {
+		return nil, nil
+	}
+	ctx := context.Background()
+	payloadKeys := make([]string, 0, len(msgIds))
+	for _, msgId := range msgIds {
+		payloadKeys = append(payloadKeys, pendingPayloadKey(msgId))
+	}
+	payloads, err := r.rdb.MGet(ctx, payloadKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var result []*model.PendingDelivery
+	for i, msgId := range msgIds {
+		count, countOk := attempts[i].(string)
+		payload, payloadOk := payloads[i].(string)
+		if !countOk || !payloadOk {
+			_ = r.RemovePending(userId, msgId)
+			continue
+		}
+		n, _ := strconv.Atoi(count)
+		result = append(result, &model.PendingDelivery{MsgId: msgId, Payload: payload, Attempts: n})
+	}
+	return result, nil
+}
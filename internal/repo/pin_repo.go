@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"errors"
+	"my-chat/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 会话的置顶数已经到上限
+var ErrPinLimitReached = errors.New("pin limit reached")
+
+type PinRepository interface {
+	Add(pin *model.PinnedMessage, limit int) (bool, error)
+	Remove(convId, msgUuid string) (bool, error)
+	List(convId string) ([]*model.PinnedMessage, error)
+}
+type pinRepository struct {
+	db *gorm.DB
+}
+
+func NewPinRepository(db *gorm.DB) PinRepository {
+	return &pinRepository{db: db}
+}
+
+// 置顶，已经置顶过返回false（不占用上限检查），超过上限返回ErrPinLimitReached
+// 先锁住会话下的置顶记录再计数，并发置顶排队执行，不会超过上限
+func (r *pinRepository) Add(pin *model.PinnedMessage, limit int) (bool, error) {
+	added := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var msgUuids []string
+		err := tx.Model(&model.PinnedMessage{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("conv_id = ?", pin.ConvId).
+			Pluck("msg_uuid", &msgUuids).Error
+		if err != nil {
+			return err
+		}
+		//已经置顶过的直接返回，满员时重复置顶不报超限
+		for _, msgUuid := range msgUuids {
+			if msgUuid == pin.MsgUuid {
+				return nil
+			}
+		}
+		//撤回和过期的消息不展示，也不占名额
+		var count int64
+		err = tx.Table("pinned_messages AS p").
+			Joins("JOIN messages m ON m.uuid = p.msg_uuid AND m.status = ? AND m.deleted_at IS NULL", model.MsgStatusNormal).
+			Where("p.conv_id = ? AND p.deleted_at IS NULL", pin.ConvId).
+			Where("(m.expire_at = 0 OR m.expire_at > ?)", time.Now().Unix()).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(limit) {
+			return ErrPinLimitReached
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(pin)
+		if result.Error != nil {
+			return result.Error
+		}
+		added = result.RowsAffected > 0
+		return nil
+	})
+	return added, err
+}
+
+// 取消置顶，物理删除，方便再次置顶
+func (r *pinRepository) Remove(convId, msgUuid string) (bool, error) {
+	result := r.db.Unscoped().
+		Where("conv_id = ? AND msg_uuid = ?", convId, msgUuid).
+		Delete(&model.PinnedMessage{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// 最近置顶的在前
+func (r *pinRepository) List(convId string) ([]*model.PinnedMessage, error) {
+	var pins []*model.PinnedMessage
+	err := r.db.Where("conv_id = ?", convId).Order("id DESC").Find(&pins).Error
+	return pins, err
+}
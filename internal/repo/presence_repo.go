@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"my-chat/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 在线设备的过期时间，心跳会不断续期，节点挂了到期自动下线
+const PresenceTTL = 90 * time.Second
+
+type PresenceRepository interface {
+	AddDevice(userId, deviceId string) (bool, error)
+	RemoveDevice(userId, deviceId string) (bool, error)
+	RefreshDevices(devices map[string][]string) error
+	GetPresence(userIds []string) (map[string]*model.Presence, error)
+}
+type presenceRepository struct {
+	rdb *redis.Client
+}
+
+func NewPresenceRepository(rdb *redis.Client) PresenceRepository {
+	return &presenceRepository{rdb: rdb}
+}
+
+// HASH存在线设备 deviceId -> 上线时间
+func presenceDevicesKey(userId string) string {
+	return fmt.Sprintf("im:presence:devices:%s", userId)
+}
+
+func lastSeenKey(userId string) string {
+	return fmt.Sprintf("im:presence:last_seen:%s", userId)
+}
+
+// 登记在线设备，返回是不是刚从离线变成在线
+func (r *presenceRepository) AddDevice(userId, deviceId string) (bool, error) {
+	ctx := context.Background()
+	now := time.Now().Unix()
+	pipe := r.rdb.TxPipeline()
+	added := pipe.HSet(ctx, presenceDevicesKey(userId), deviceId, now)
+	count := pipe.HLen(ctx, presenceDevicesKey(userId))
+	pipe.Expire(ctx, presenceDevicesKey(userId), PresenceTTL)
+	pipe.Set(ctx, lastSeenKey(userId), now, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return added.Val() == 1 && count.Val() == 1, nil
+}
+
+// 移除在线设备，返回是不是所有设备都下线了
+func (r *presenceRepository) RemoveDevice(userId, deviceId string) (bool, error) {
+	ctx := context.Background()
+	pipe := r.rdb.TxPipeline()
+	removed := pipe.HDel(ctx, presenceDevicesKey(userId), deviceId)
+	count := pipe.HLen(ctx, presenceDevicesKey(userId))
+	pipe.Set(ctx, lastSeenKey(userId), time.Now().Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return removed.Val() == 1 && count.Val() == 0, nil
+}
+
+// 心跳续期 userId -> deviceIds，顺便把漏掉的设备补回去
+func (r *presenceRepository) RefreshDevices(devices map[string][]string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	now := time.Now().Unix()
+	pipe := r.rdb.Pipeline()
+	for userId, deviceIds := range devices {
+		for _, deviceId := range deviceIds {
+			pipe.HSetNX(ctx, presenceDevicesKey(userId), deviceId, now)
+		}
+		pipe.Expire(ctx, presenceDevicesKey(userId), PresenceTTL)
+		pipe.Set(ctx, lastSeenKey(userId), now, 0)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *presenceRepository) GetPresence(userIds []string) (map[string]*model.Presence, error) {
+	result := make(map[string]*model.Presence, len(userIds))
+	if len(userIds) == 0 {
+		return result, nil
+	}
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	onlineCmds := make([]*redis.IntCmd, len(userIds))
+	lastSeenCmds := make([]*redis.StringCmd, len(userIds))
+	for i, userId := range userIds {
+		onlineCmds[i] = pipe.Exists(ctx, presenceDevicesKey(userId))
+		lastSeenCmds[i] = pipe.Get(ctx, lastSeenKey(userId))
+	}
+	//last_seen可能不存在，redis.Nil不算错误
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, userId := range userIds {
+		lastSeen, _ := strconv.ParseInt(lastSeenCmds[i].Val(), 10, 64)
+		result[userId] = &model.Presence{
+			UserId:   userId,
+			Online:   onlineCmds[i].Val() > 0,
+			LastSeen: lastSeen,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"my-chat/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReactionRepository interface {
+	Add(reaction *model.MessageReaction) (bool, error)
+	Remove(msgUuid, userId, emoji string) (bool, error)
+	ListByMsgUuids(msgUuids []string) ([]*model.MessageReaction, error)
+}
+type reactionRepository struct {
+	db *gorm.DB
+}
+
+func NewReactionRepository(db *gorm.DB) ReactionRepository {
+	return &reactionRepository{db: db}
+}
+
+// 添加回应，已经回应过返回false
+func (r *reactionRepository) Add(reaction *model.MessageReaction) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// 取消回应，物理删除，不然唯一索引会挡住再次回应
+func (r *reactionRepository) Remove(msgUuid, userId, emoji string) (bool, error) {
+	result := r.db.Unscoped().
+		Where("msg_uuid = ? AND user_id = ? AND emoji = ?", msgUuid, userId, emoji).
+		Delete(&model.MessageReaction{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *reactionRepository) ListByMsgUuids(msgUuids []string) ([]*model.MessageReaction, error) {
+	var reactions []*model.MessageReaction
+	if len(msgUuids) == 0 {
+		return reactions, nil
+	}
+	err := r.db.Where("msg_uuid IN ?", msgUuids).Order("id ASC").Find(&reactions).Error
+	return reactions, err
+}
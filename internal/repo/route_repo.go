@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 路由过期时间，心跳续期，节点挂了到期自动失效
+const RouteTTL = 90 * time.Second
+
+// 用户连接在哪些网关节点上，多节点部署时跨节点推送用
+type RouteRepository interface {
+	Bind(userId, deviceId, nodeId string) error
+	Unbind(userId, deviceId, nodeId string) error
+	Refresh(nodeId string, devices map[string][]string) error
+	GetUserNodes(userIds []string) (map[string][]string, error)
+}
+type routeRepository struct {
+	rdb *redis.Client
+}
+
+func NewRouteRepository(rdb *redis.Client) RouteRepository {
+	return &routeRepository{rdb: rdb}
+}
+
+// HASH deviceId -> nodeId
+func routeKey(userId string) string {
+	return fmt.Sprintf("im:route:%s", userId)
+}
+
+// 只删自己节点的路由，设备可能已经重连到别的节点上了
+var unbindScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func (r *routeRepository) Bind(userId, deviceId, nodeId string) error {
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	pipe.HSet(ctx, routeKey(userId), deviceId, nodeId)
+	pipe.Expire(ctx, routeKey(userId), RouteTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *routeRepository) Unbind(userId, deviceId, nodeId string) error {
+	return unbindScript.Run(context.Background(), r.rdb, []string{routeKey(userId)}, deviceId, nodeId).Err()
+}
+
+// 心跳续期本节点上的所有连接 userId -> deviceIds
+func (r *routeRepository) Refresh(nodeId string, devices map[string][]string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	for userId, deviceIds := range devices {
+		for _, deviceId := range deviceIds {
+			pipe.HSet(ctx, routeKey(userId), deviceId, nodeId)
+		}
+		pipe.Expire(ctx, routeKey(userId), RouteTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 按节点分组 nodeId -> userIds
+func (r *routeRepository) GetUserNodes(userIds []string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	if len(userIds) == 0 {
+		return result, nil
+	}
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(userIds))
+	for i, userId := range userIds {
+		cmds[i] = pipe.HGetAll(ctx, routeKey(userId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, userId := range userIds {
+		seen := make(map[string]bool)
+		for _, nodeId := range cmds[i].Val() {
+			if seen[nodeId] {
+				continue
+			}
+			seen[nodeId] = true
+			result[nodeId] = append(result[nodeId], userId)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"my-chat/internal/model"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type SeqRepository interface {
+	NextSeq(convId string) (int64, error)
+	CurrentSeqs(convIds []string) (map[string]int64, error)
+}
+type seqRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewSeqRepository(db *gorm.DB, rdb *redis.Client) SeqRepository {
+	return &seqRepository{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+func seqKey(convId string) string {
+	return fmt.Sprintf("im:seq:%s", convId)
+}
+
+// key存在才INCR，不存在返回-1
+var incrSeqScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('INCR', KEYS[1])
+end
+return -1
+`)
+
+// key不存在时先用传入的值初始化再INCR，并发初始化时只有第一个生效
+var seedAndIncrSeqScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return redis.call('INCR', KEYS[1])
+`)
+
+// 用redis INCR给会话分配递增序号，seq不设过期时间
+func (r *seqRepository) NextSeq(convId string) (int64, error) {
+	ctx := context.Background()
+	key := seqKey(convId)
+	seq, err := incrSeqScript.Run(ctx, r.rdb, []string{key}).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if seq >= 0 {
+		return seq, nil
+	}
+	//key不存在说明是新会话或者redis数据丢了，用库里的最大seq初始化，防止seq回退
+	//初始化和INCR在一个脚本里完成，避免别的调用方在初始化之前拿到已经用过的seq
+	var maxSeq int64
+	err = r.db.Model(&model.Message{}).
+		Where("conv_id = ?", convId).
+		Select("COALESCE(MAX(seq), 0)").
+		Scan(&maxSeq).Error
+	if err != nil {
+		return 0, err
+	}
+	return seedAndIncrSeqScript.Run(ctx, r.rdb, []string{key}, maxSeq).Int64()
+}
+
+// 批量查会话当前最大seq，redis里没有的查库
+func (r *seqRepository) CurrentSeqs(convIds []string) (map[string]int64, error) {
+	result := make(map[string]int64, len(convIds))
+	if len(convIds) == 0 {
+		return result, nil
+	}
+	keys := make([]string, 0, len(convIds))
+	for _, convId := range convIds {
+		keys = append(keys, seqKey(convId))
+	}
+	values, err := r.rdb.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			missing = append(missing, convIds[i])
+			continue
+		}
+		seq, _ := strconv.ParseInt(str, 10, 64)
+		result[convIds[i]] = seq
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		ConvId string
+		MaxSeq int64
+	}
+	err = r.db.Model(&model.Message{}).
+		Select("conv_id, MAX(seq) AS max_seq").
+		Where("conv_id IN (?)", missing).
+		Group("conv_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ConvId] = row.MaxSeq
+	}
+	return result, nil
+}
@@ -3,8 +3,10 @@ package repo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"my-chat/internal/model"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -15,6 +17,13 @@ import (
 type SessionRepository interface {
 	GetList(userId string) ([]*model.Session, error)
 	UpsertSession(session *model.Session) error
+	UpdateLastMsg(userId, targetId, lastMsg string) error
+	IncrUnread(userId, targetId string) error
+	ClearUnread(userId, targetId string) error
+	GetUnreadCounts(userId string) (map[string]int, error)
+	UpdateReadSeq(userId, targetId string, seq int64) error
+	GetReadSeq(userId, targetId string) (int64, error)
+	SetMute(userId, targetId string, chatType, mute int) error
 
 	GetListFromCache(userId string) ([]*model.SessionCache, error)
 	SaveSessionToCache(userId string, session *model.SessionCache) error
@@ -97,11 +106,113 @@ func (s *sessionRepository) GetList(userId string) ([]*model.Session, error) {
 
 func (s *sessionRepository) UpsertSession(session *model.Session) error {
 	return s.db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "user_id"}, {Name: "target_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"last_msg", "last_time", "unread_cnt", "updated_at", "type"}),
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "target_id"}},
+		//未读数由IncrUnread/ClearUnread单独维护，这里不覆盖
+		DoUpdates: clause.AssignmentColumns([]string{"last_msg", "last_time", "updated_at", "type"}),
 	}).Create(session).Error
 }
 
+// 只改最新消息预览，不动未读数和时间
+func (s *sessionRepository) UpdateLastMsg(userId, targetId, lastMsg string) error {
+	return s.db.Model(&model.Session{}).
+		Where("user_id = ? AND target_id = ?", userId, targetId).
+		Update("last_msg", lastMsg).Error
+}
+
+// 未读数：redis HASH做计数，mysql的sessions.unread_cnt兜底
+func unreadKey(userId string) string {
+	return fmt.Sprintf("im:unread:%s", userId)
+}
+
+// 字段存在才自增，不存在返回-1，由调用方用数据库的值初始化，避免缓存丢失后从0开始计数
+var incrIfExistsScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	return redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+end
+return -1
+`)
+
+func (s *sessionRepository) IncrUnread(userId, targetId string) error {
+	ctx := context.Background()
+	err := s.db.Model(&model.Session{}).
+		Where("user_id = ? AND target_id = ?", userId, targetId).
+		Update("unread_cnt", gorm.Expr("unread_cnt + ?", 1)).Error
+	if err != nil {
+		return err
+	}
+	cnt, err := incrIfExistsScript.Run(ctx, s.rdb, []string{unreadKey(userId)}, targetId, 1).Int64()
+	if err != nil {
+		return err
+	}
+	if cnt >= 0 {
+		return nil
+	}
+	var session model.Session
+	err = s.db.Select("unread_cnt").
+		Where("user_id = ? AND target_id = ?", userId, targetId).
+		First(&session).Error
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, unreadKey(userId), targetId, session.UnreadCnt).Err()
+}
+
+func (s *sessionRepository) ClearUnread(userId, targetId string) error {
+	err := s.db.Model(&model.Session{}).
+		Where("user_id = ? AND target_id = ?", userId, targetId).
+		Update("unread_cnt", 0).Error
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(context.Background(), unreadKey(userId), targetId, 0).Err()
+}
+
+// 只返回redis里有的，没有的以数据库为准
+func (s *sessionRepository) GetUnreadCounts(userId string) (map[string]int, error) {
+	values, err := s.rdb.HGetAll(context.Background(), unreadKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(values))
+	for targetId, v := range values {
+		if cnt, err := strconv.Atoi(v); err == nil {
+			result[targetId] = cnt
+		}
+	}
+	return result, nil
+}
+
+// 推进单聊的已读位置，只能往前走
+func (s *sessionRepository) UpdateReadSeq(userId, targetId string, seq int64) error {
+	return s.db.Model(&model.Session{}).
+		Where("user_id = ? AND target_id = ? AND read_seq < ?", userId, targetId, seq).
+		Update("read_seq", seq).Error
+}
+
+func (s *sessionRepository) GetReadSeq(userId, targetId string) (int64, error) {
+	var session model.Session
+	err := s.db.Select("read_seq").
+		Where("user_id = ? AND target_id = ?", userId, targetId).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return session.ReadSeq, err
+}
+
+// 设置免打扰，群会话平时没有记录，这里按需创建
+func (s *sessionRepository) SetMute(userId, targetId string, chatType, mute int) error {
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "target_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"mute", "updated_at"}),
+	}).Create(&model.Session{
+		UserId:   userId,
+		TargetId: targetId,
+		Type:     chatType,
+		Mute:     mute,
+	}).Error
+}
+
 func NewSessionRepository(db *gorm.DB, rdb *redis.Client) SessionRepository {
 	return &sessionRepository{
 		db:  db,
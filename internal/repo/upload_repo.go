@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"my-chat/internal/model"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 所有分片上传会话按过期时间排序，清理任务据此找出过期的会话
+const uploadExpireKey = "im:upload:expire"
+
+type UploadRepository interface {
+	CreateSession(session *model.UploadSession, expireAt int64) error
+	GetSession(uploadId string) (*model.UploadSession, error)
+	AddChunk(uploadId string, index int, expireAt int64) error
+	GetChunks(uploadId string) ([]int, error)
+	DeleteSession(uploadId string) error
+	Claim(uploadId string, ttl time.Duration) (bool, error)
+	Unclaim(uploadId string) error
+	GetExpired(now int64, limit int64) ([]string, error)
+}
+type uploadRepository struct {
+	rdb *redis.Client
+}
+
+func NewUploadRepository(rdb *redis.Client) UploadRepository {
+	return &uploadRepository{rdb: rdb}
+}
+
+func uploadSessionKey(uploadId string) string {
+	return fmt.Sprintf("im:upload:session:%s", uploadId)
+}
+
+// SET存已收到的分片序号
+func uploadChunksKey(uploadId string) string {
+	return fmt.Sprintf("im:upload:chunks:%s", uploadId)
+}
+
+// 合并或清理时的占用标记，同一个上传会话同时只能有一个人在处理
+func uploadClaimKey(uploadId string) string {
+	return fmt.Sprintf("im:upload:claim:%s", uploadId)
+}
+
+// 会话本身不设TTL，由清理任务删除，否则过期后就找不到要清理的分片了
+func (r *uploadRepository) CreateSession(session *model.UploadSession, expireAt int64) error {
+	ctx := context.Background()
+	dataBytes, _ := json.Marshal(session)
+	pipe := r.rdb.TxPipeline()
+	pipe.Set(ctx, uploadSessionKey(session.UploadId), string(dataBytes), 0)
+	pipe.ZAdd(ctx, uploadExpireKey, redis.Z{Score: float64(expireAt), Member: session.UploadId})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *uploadRepository) GetSession(uploadId string) (*model.UploadSession, error) {
+	val, err := r.rdb.Get(context.Background(), uploadSessionKey(uploadId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var session model.UploadSession
+	if err := json.Unmarshal([]byte(val), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// 记录分片并顺延过期时间，上传还在进行就不会被清理
+func (r *uploadRepository) AddChunk(uploadId string, index int, expireAt int64) error {
+	ctx := context.Background()
+	pipe := r.rdb.TxPipeline()
+	pipe.SAdd(ctx, uploadChunksKey(uploadId), index)
+	pipe.ZAdd(ctx, uploadExpireKey, redis.Z{Score: float64(expireAt), Member: uploadId})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *uploadRepository) GetChunks(uploadId string) ([]int, error) {
+	members, err := r.rdb.SMembers(context.Background(), uploadChunksKey(uploadId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]int, 0, len(members))
+	for _, m := range members {
+		if index, err := strconv.Atoi(m); err == nil {
+			chunks = append(chunks, index)
+		}
+	}
+	sort.Ints(chunks)
+	return chunks, nil
+}
+
+func (r *uploadRepository) DeleteSession(uploadId string) error {
+	ctx := context.Background()
+	pipe := r.rdb.TxPipeline()
+	pipe.Del(ctx, uploadSessionKey(uploadId), uploadChunksKey(uploadId), uploadClaimKey(uploadId))
+	pipe.ZRem(ctx, uploadExpireKey, uploadId)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SETNX占用上传会话，已经被别人占用返回false；带TTL防止进程挂了标记一直不释放
+func (r *uploadRepository) Claim(uploadId string, ttl time.Duration) (bool, error) {
+	return r.rdb.SetNX(context.Background(), uploadClaimKey(uploadId), 1, ttl).Result()
+}
+
+// 处理失败时释放占用，允许重试
+func (r *uploadRepository) Unclaim(uploadId string) error {
+	return r.rdb.Del(context.Background(), uploadClaimKey(uploadId)).Err()
+}
+
+func (r *uploadRepository) GetExpired(now int64, limit int64) ([]string, error) {
+	return r.rdb.ZRangeByScore(context.Background(), uploadExpireKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: limit,
+	}).Result()
+}
@@ -23,12 +23,14 @@ func (r *userRepository) UpdateUser(user *model.User) error {
 		Updates(user).Error
 }
 
+// 按用户UUID批量查，返回以UUID为key的map
+// 调用方传的都是联系人、群成员、消息里的用户UUID，不是自增主键，所以按uuid列查
 func (r *userRepository) FindUsersByIDs(ids []string) (map[string]*model.User, error) {
 	var users []*model.User
 	if len(ids) == 0 {
 		return make(map[string]*model.User), nil
 	}
-	err := r.db.Where("id IN (?)", ids).Find(&users).Error
+	err := r.db.Where("uuid IN (?)", ids).Find(&users).Error
 	if err != nil {
 		return nil, err
 	}
@@ -1,16 +1,24 @@
 package service
 
 import (
-	//"my-chat/internal/model"
+	"context"
+	"errors"
+	"my-chat/internal/model"
+	"my-chat/internal/mq"
 	"my-chat/internal/repo"
+	"my-chat/pkg/errno"
 )
 
+// 单次查看死信的最大条数
+const maxDeadLetterLimit = 100
+
 type AdminService struct {
 	adminRepo repo.AdminRepository
+	mqClient  *mq.KafkaClient
 }
 
-func NewAdminService(adminRepo repo.AdminRepository) *AdminService {
-	return &AdminService{adminRepo: adminRepo}
+func NewAdminService(adminRepo repo.AdminRepository, mqClient *mq.KafkaClient) *AdminService {
+	return &AdminService{adminRepo: adminRepo, mqClient: mqClient}
 }
 func (s *AdminService) GetUserList(page, limit int) (map[string]interface{}, error) {
 	users, total, err := s.adminRepo.GetAllUsers(page, limit)
@@ -39,8 +47,25 @@ func (s *AdminService) GetGroupList(page, limit int) (map[string]interface{}, er
 	}, nil
 }
 func (s *AdminService) BanGroup(uuid string) error {
-	return s.adminRepo.UpdateUserStatus(uuid, 2)
+	return s.adminRepo.UpdateGroupStatus(uuid, model.GroupStatusDisabled)
 }
 func (s *AdminService) UnBanGroup(uuid string) error {
-	return s.adminRepo.UpdateUserStatus(uuid, 1)
+	return s.adminRepo.UpdateGroupStatus(uuid, model.GroupStatusNormal)
+}
+
+// 查看死信队列
+func (s *AdminService) GetDeadLetters(partition int, offset int64, limit int) ([]*mq.DeadLetter, error) {
+	if limit <= 0 || limit > maxDeadLetterLimit {
+		limit = maxDeadLetterLimit
+	}
+	return s.mqClient.ReadDeadLetters(context.Background(), partition, offset, limit)
+}
+
+// 重放一条死信，重新走一遍消费流程
+func (s *AdminService) ReplayDeadLetter(partition int, offset int64) (*mq.DeadLetter, error) {
+	letter, err := s.mqClient.ReplayDeadLetter(context.Background(), partition, offset)
+	if errors.Is(err, mq.ErrDeadLetterNotFound) {
+		return nil, errno.ErrDeadLetterNotFound
+	}
+	return letter, err
 }
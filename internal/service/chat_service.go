@@ -2,31 +2,118 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
+	"my-chat/internal/config"
 	"my-chat/internal/model"
 	"my-chat/internal/repo"
+	"my-chat/pkg/errno"
 	"my-chat/pkg/util/snowflake"
+	"my-chat/pkg/zlog"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// 默认撤回时限
+const defaultRecallWindow = 2 * time.Minute
+
+// 默认编辑时限
+const defaultEditWindow = 15 * time.Minute
+
+// 默认每个会话最多置顶条数
+const defaultMaxPins = 10
+
+// 默认去重窗口，窗口外的重发靠数据库唯一约束兜底
+const defaultDedupWindow = 24 * time.Hour
+
 type ChatService struct {
-	msgRepo   repo.MessageRepository
-	groupRepo repo.GroupRepository
+	msgRepo      repo.MessageRepository
+	groupRepo    repo.GroupRepository
+	seqRepo      repo.SeqRepository
+	sessionRepo  repo.SessionRepository
+	userRepo     repo.UserRepository
+	contactRepo  repo.ContactRepository
+	dedupRepo    repo.DedupRepository
+	fileRepo     repo.AttachmentRepository
+	reactionRepo repo.ReactionRepository
+	mentionRepo  repo.MentionRepository
+	pinRepo      repo.PinRepository
+	settingRepo  repo.ConvSettingRepository
+
+	recallWindow time.Duration
+	dedupWindow  time.Duration
+	editWindow   time.Duration
+	maxPins      int
 }
 
-func NewChatService(msgRepo repo.MessageRepository, groupRepo repo.GroupRepository) *ChatService {
+func NewChatService(msgRepo repo.MessageRepository, groupRepo repo.GroupRepository, seqRepo repo.SeqRepository,
+	sessionRepo repo.SessionRepository, userRepo repo.UserRepository, contactRepo repo.ContactRepository, dedupRepo repo.DedupRepository,
+	fileRepo repo.AttachmentRepository, reactionRepo repo.ReactionRepository,
+	mentionRepo repo.MentionRepository, pinRepo repo.PinRepository, settingRepo repo.ConvSettingRepository, cfg *config.ChatConfig) *ChatService {
+	recallWindow := defaultRecallWindow
+	if cfg != nil && cfg.RecallWindow > 0 {
+		recallWindow = time.Duration(cfg.RecallWindow) * time.Second
+	}
+	dedupWindow := defaultDedupWindow
+	if cfg != nil && cfg.DedupWindow > 0 {
+		dedupWindow = time.Duration(cfg.DedupWindow) * time.Second
+	}
+	editWindow := defaultEditWindow
+	if cfg != nil && cfg.EditWindow > 0 {
+		editWindow = time.Duration(cfg.EditWindow) * time.Second
+	}
+	maxPins := defaultMaxPins
+	if cfg != nil && cfg.MaxPins > 0 {
+		maxPins = cfg.MaxPins
+	}
 	return &ChatService{
-		msgRepo:   msgRepo,
-		groupRepo: groupRepo,
+		msgRepo:      msgRepo,
+		groupRepo:    groupRepo,
+		seqRepo:      seqRepo,
+		sessionRepo:  sessionRepo,
+		userRepo:     userRepo,
+		contactRepo:  contactRepo,
+		dedupRepo:    dedupRepo,
+		fileRepo:     fileRepo,
+		reactionRepo: reactionRepo,
+		mentionRepo:  mentionRepo,
+		pinRepo:      pinRepo,
+		settingRepo:  settingRepo,
+		recallWindow: recallWindow,
+		dedupWindow:  dedupWindow,
+		editWindow:   editWindow,
+		maxPins:      maxPins,
 	}
 }
 
 type MsgPayload struct {
-	Uuid       string `json:"uuid"`
-	FromUserId string `json:"form_user_id"`
-	ToId       string `json:"to_id"`
-	Content    string `json:"content"`
-	Type       int    `json:"type"`
-	MediaType  int    `json:"media_type"`
-	CreatedAt  string `json:"created_at"`
+	Uuid       string                  `json:"uuid"`
+	FromUserId string                  `json:"form_user_id"`
+	ToId       string                  `json:"to_id"`
+	Content    string                  `json:"content"`
+	Type       int                     `json:"type"`
+	MediaType  int                     `json:"media_type"`
+	Media      *model.MediaInfo        `json:"media,omitempty"`
+	Seq        int64                   `json:"seq"`
+	Recalled   bool                    `json:"recalled"`
+	Edited     bool                    `json:"edited"`
+	EditedAt   int64                   `json:"edited_at,omitempty"`
+	ReplyTo    string                  `json:"reply_to,omitempty"`
+	Quote      *model.QuoteInfo        `json:"quote,omitempty"`
+	RootId     string                  `json:"root_id,omitempty"`
+	Reactions  []model.ReactionSummary `json:"reactions,omitempty"`
+	MentionIds []string                `json:"mention_ids,omitempty"`
+	MentionAll bool                    `json:"mention_all,omitempty"`
+	Forwarded  bool                    `json:"forwarded,omitempty"`
+	Record     *model.ChatRecord       `json:"record,omitempty"` //聊天记录消息的摘要，完整内容走展开接口
+	ExpireAt   int64                   `json:"expire_at,omitempty"`
+	//以下两项只有话题根消息才有
+	ThreadCount  int    `json:"thread_count,omitempty"`
+	ThreadLastAt int64  `json:"thread_last_at,omitempty"`
+	CreatedAt    string `json:"created_at"`
 }
 
 func (s *ChatService) SaveAndFactory(fromId, toId, content string, chatType, mediaType int) ([]byte, error) {
@@ -66,23 +153,1082 @@ func (s *ChatService) GetHistory(userId, targetId string, chatType int) ([]MsgPa
 	}
 	var result []MsgPayload
 	for _, msg := range messages {
-		result = append(result, MsgPayload{
-			Uuid:       msg.Uuid,
-			FromUserId: msg.FromUserId,
-			ToId:       msg.ToId,
-			Content:    msg.Content,
-			Type:       msg.Type,
-			MediaType:  msg.MediaType,
-			CreatedAt:  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-		})
+		result = append(result, toMsgPayload(msg))
 	}
+	s.fillQuotes(result)
+	s.fillReactions(result)
 	return result, nil
 }
+
+// 批量补上表情回应，已撤回的消息不展示
+func (s *ChatService) fillReactions(payloads []MsgPayload) {
+	uuids := make([]string, 0, len(payloads))
+	for _, payload := range payloads {
+		if !payload.Recalled {
+			uuids = append(uuids, payload.Uuid)
+		}
+	}
+	if len(uuids) == 0 {
+		return
+	}
+	reactions, err := s.reactionRepo.ListByMsgUuids(uuids)
+	if err != nil {
+		zlog.Error("load reactions failed", zap.Error(err))
+		return
+	}
+	summaries := model.AggregateReactions(reactions)
+	for i := range payloads {
+		if !payloads[i].Recalled {
+			payloads[i].Reactions = summaries[payloads[i].Uuid]
+		}
+	}
+}
+
+// 表情最长字节数，和表字段长度一致
+const maxEmojiLen = 32
+
+// 添加或取消表情回应，会话参与者都可以操作；返回消息和这条消息最新的聚合结果
+// changed为false说明重复添加或者取消了不存在的回应，不需要推送
+func (s *ChatService) ReactMessage(userId, msgId, emoji string, add bool) (*model.Message, []model.ReactionSummary, bool, error) {
+	if strings.TrimSpace(emoji) == "" || len(emoji) > maxEmojiLen {
+		return nil, nil, false, errno.ErrReactionInvalid
+	}
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if msg.Status == model.MsgStatusRecalled {
+		return nil, nil, false, errno.ErrMessageRecalled
+	}
+	if err := s.checkParticipant(userId, msg); err != nil {
+		return nil, nil, false, err
+	}
+	var changed bool
+	if add {
+		changed, err = s.reactionRepo.Add(&model.MessageReaction{MsgUuid: msg.Uuid, UserId: userId, Emoji: emoji})
+	} else {
+		changed, err = s.reactionRepo.Remove(msg.Uuid, userId, emoji)
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	reactions, err := s.reactionRepo.ListByMsgUuids([]string{msg.Uuid})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return msg, model.AggregateReactions(reactions)[msg.Uuid], changed, nil
+}
+
+// 批量补上引用消息的预览，查不到的（比如被删了）就不带
+func (s *ChatService) fillQuotes(payloads []MsgPayload) {
+	var uuids []string
+	for _, payload := range payloads {
+		if payload.ReplyTo != "" {
+			uuids = append(uuids, payload.ReplyTo)
+		}
+	}
+	if len(uuids) == 0 {
+		return
+	}
+	quoted, err := s.msgRepo.FindByUuids(uuids)
+	if err != nil {
+		zlog.Error("load quoted messages failed", zap.Error(err))
+		return
+	}
+	quotes := make(map[string]*model.QuoteInfo, len(quoted))
+	for _, msg := range quoted {
+		quotes[msg.Uuid] = model.NewQuoteInfo(msg)
+	}
+	for i := range payloads {
+		if payloads[i].ReplyTo != "" {
+			payloads[i].Quote = quotes[payloads[i].ReplyTo]
+		}
+	}
+}
+
+// 数据库消息转成返回给客户端的结构
+func toMsgPayload(msg *model.Message) MsgPayload {
+	payload := MsgPayload{
+		Uuid:         msg.Uuid,
+		FromUserId:   msg.FromUserId,
+		ToId:         msg.ToId,
+		Content:      msg.Content,
+		Type:         msg.Type,
+		MediaType:    msg.MediaType,
+		Seq:          msg.Seq,
+		Edited:       msg.EditedAt > 0,
+		EditedAt:     msg.EditedAt,
+		ReplyTo:      msg.ReplyTo,
+		MentionIds:   msg.MentionUserIds(),
+		MentionAll:   msg.MentionAll,
+		Forwarded:    msg.Forwarded,
+		ExpireAt:     msg.ExpireAt,
+		RootId:       msg.RootId,
+		ThreadCount:  msg.ThreadCount,
+		ThreadLastAt: msg.ThreadLastAt,
+		CreatedAt:    msg.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	payload.Media = msg.MediaInfo()
+	if record := msg.ChatRecord(); record != nil {
+		payload.Record = record.Summary()
+	}
+	//已撤回的消息不再返回原内容
+	if msg.Status == model.MsgStatusRecalled {
+		payload.Content = model.RecalledPlaceholder
+		payload.MediaType = model.MediaTypeText
+		payload.Media = nil
+		payload.Record = nil
+		payload.Recalled = true
+		payload.Edited = false
+		payload.EditedAt = 0
+	}
+	return payload
+}
 func (s *ChatService) BatchSave(messages []*model.Message) error {
 	return s.msgRepo.BatchCreate(messages)
 }
 
+// 客户端消息ID去重：第一次发送返回true；重发返回false和第一次分配的服务端ID、时间
+func (s *ChatService) ReserveClientMsg(senderId, clientMsgId string, record *model.SendRecord) (*model.SendRecord, bool, error) {
+	return s.dedupRepo.Reserve(senderId, clientMsgId, record, s.dedupWindow)
+}
+
+// 消息没发出去，释放去重占位
+func (s *ChatService) ReleaseClientMsg(senderId, clientMsgId string) error {
+	return s.dedupRepo.Release(senderId, clientMsgId)
+}
+
+// 插入失败时查是否已经落过库（kafka重复投递或去重窗口外的重发），是的话返回已有消息
+func (s *ChatService) FindDuplicate(message *model.Message) (*model.Message, bool) {
+	var existing *model.Message
+	var err error
+	if message.ClientMsgId != nil && *message.ClientMsgId != "" {
+		existing, err = s.msgRepo.FindByClientMsgId(message.FromUserId, *message.ClientMsgId)
+	} else {
+		existing, err = s.msgRepo.FindByUuid(message.Uuid)
+	}
+	if err != nil {
+		return nil, false
+	}
+	return existing, true
+}
+
+// 批量查重，返回和入参一一对应的结果，kafka重投的消息在分配seq之前就能跳过
+func (s *ChatService) FindDuplicates(messages []*model.Message) ([]bool, error) {
+	existing, err := s.msgRepo.FindExisting(messages)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make(map[string]bool, len(existing))
+	clientKeys := make(map[string]bool, len(existing))
+	for _, msg := range existing {
+		uuids[msg.Uuid] = true
+		if msg.ClientMsgId != nil {
+			clientKeys[msg.FromUserId+":"+*msg.ClientMsgId] = true
+		}
+	}
+	result := make([]bool, len(messages))
+	for i, msg := range messages {
+		result[i] = uuids[msg.Uuid] ||
+			(msg.ClientMsgId != nil && clientKeys[msg.FromUserId+":"+*msg.ClientMsgId])
+	}
+	return result, nil
+}
+
 // 单条插入消息
 func (s *ChatService) InsertMessage(message *model.Message) error {
 	return s.msgRepo.CreateMessage(message)
 }
+
+// 撤回消息：发送者可在时限内撤回自己的消息，群主/管理员可撤回群内任意消息
+func (s *ChatService) RecallMessage(operatorId, msgId string) (*model.Message, error) {
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status == model.MsgStatusRecalled {
+		return nil, errno.ErrMessageRecalled
+	}
+	if err := s.checkRecallPermission(operatorId, msg); err != nil {
+		return nil, err
+	}
+	if err := s.msgRepo.RecallMessage(msg.Uuid, operatorId); err != nil {
+		return nil, err
+	}
+	msg.Status = model.MsgStatusRecalled
+	msg.RecallBy = operatorId
+	return msg, nil
+}
+
+// 检查用户能不能往会话里发东西：单聊要求互为好友且双方都没拉黑，群聊要求是群成员
+func (s *ChatService) CheckSendPermission(userId string, chatType int, targetId string) error {
+	user, err := s.userRepo.FindByUuid(userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errno.ErrUserBanned
+		}
+		return err
+	}
+	if user.Status == model.UserStatusDisabled {
+		return errno.ErrUserBanned
+	}
+	if chatType == model.MsgTypeGroup {
+		group, err := s.groupRepo.FindGroup(targetId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errno.ErrGroupNotFound
+			}
+			return err
+		}
+		if group.Status == model.GroupStatusDisabled {
+			return errno.ErrGroupBanned
+		}
+		isMember, err := s.groupRepo.IsMember(targetId, userId)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return errno.ErrNotGroupMember
+		}
+		return nil
+	}
+	if chatType != model.MsgTypeSingle {
+		return errno.ErrBind
+	}
+	mine, err := s.findContact(userId, targetId)
+	if err != nil {
+		return err
+	}
+	theirs, err := s.findContact(targetId, userId)
+	if err != nil {
+		return err
+	}
+	if mine.Type == model.ContactTypeBlack || theirs.Type == model.ContactTypeBlack {
+		return errno.ErrBlacklisted
+	}
+	return nil
+}
+func (s *ChatService) findContact(ownerId, targetId string) (*model.Contact, error) {
+	contact, err := s.contactRepo.FindContact(ownerId, targetId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errno.ErrNotFriend
+		}
+		return nil, err
+	}
+	return contact, nil
+}
+func (s *ChatService) findMessage(msgId string) (*model.Message, error) {
+	msg, err := s.msgRepo.FindByUuid(msgId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errno.ErrMessageNotFound
+		}
+		return nil, err
+	}
+	//过期还没清理的阅后即焚消息当作不存在
+	if msg.ExpireAt > 0 && msg.ExpireAt <= time.Now().Unix() {
+		return nil, errno.ErrMessageNotFound
+	}
+	return msg, nil
+}
+
+// 检查用户是不是消息所在会话的参与者
+func (s *ChatService) checkParticipant(userId string, msg *model.Message) error {
+	if msg.Type == model.MsgTypeGroup {
+		isMember, err := s.groupRepo.IsMember(msg.ToId, userId)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return errno.ErrNotGroupMember
+		}
+		return nil
+	}
+	if msg.FromUserId != userId && msg.ToId != userId {
+		return errno.ErrNotParticipant
+	}
+	return nil
+}
+func (s *ChatService) checkRecallPermission(operatorId string, msg *model.Message) error {
+	if msg.Type == model.MsgTypeGroup {
+		member, err := s.groupRepo.GetMember(msg.ToId, operatorId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errno.ErrNotGroupMember
+			}
+			return err
+		}
+		//群主和管理员不受时限限制
+		if member.Role == model.RoleOwner || member.Role == model.RoleAdmin {
+			return nil
+		}
+	}
+	if msg.FromUserId != operatorId {
+		return errno.ErrRecallDenied
+	}
+	if time.Since(msg.CreatedAt) > s.recallWindow {
+		return errno.ErrRecallTimeout
+	}
+	return nil
+}
+
+// 编辑消息：只有发送者本人能在时限内修改自己的文本消息
+func (s *ChatService) EditMessage(operatorId, msgId, content string) (*model.Message, error) {
+	if content == "" {
+		return nil, errno.ErrBind
+	}
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status == model.MsgStatusRecalled {
+		return nil, errno.ErrMessageRecalled
+	}
+	if msg.FromUserId != operatorId || msg.MediaType != model.MediaTypeText {
+		return nil, errno.ErrEditDenied
+	}
+	if time.Since(msg.CreatedAt) > s.editWindow {
+		return nil, errno.ErrEditTimeout
+	}
+	editedAt := time.Now().Unix()
+	if err := s.msgRepo.EditMessage(msg.Uuid, content, operatorId, editedAt); err != nil {
+		//编辑过程中被撤回了
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errno.ErrMessageRecalled
+		}
+		return nil, err
+	}
+	msg.Content = content
+	msg.EditedAt = editedAt
+	return msg, nil
+}
+
+// 查看历史版本：发送者本人，以及群消息所在群的群主/管理员
+func (s *ChatService) GetRevisions(userId, msgId string) ([]*model.MessageRevision, error) {
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status == model.MsgStatusRecalled {
+		return nil, errno.ErrMessageRecalled
+	}
+	if msg.FromUserId != userId {
+		if msg.Type != model.MsgTypeGroup {
+			return nil, errno.ErrEditDenied
+		}
+		member, err := s.groupRepo.GetMember(msg.ToId, userId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errno.ErrNotGroupMember
+			}
+			return nil, err
+		}
+		if member.Role != model.RoleOwner && member.Role != model.RoleAdmin {
+			return nil, errno.ErrEditDenied
+		}
+	}
+	return s.msgRepo.GetRevisions(msg.Uuid)
+}
+
+// 判断是不是会话里的最新一条消息，用于决定是否需要更新会话预览
+func (s *ChatService) IsLatestMessage(msg *model.Message) (bool, error) {
+	latest, err := s.msgRepo.GetMessages(msg.FromUserId, msg.ToId, msg.Type, 0, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(latest) > 0 && latest[0].Uuid == msg.Uuid, nil
+}
+
+// 查msg所在会话里现在的最新一条消息，会话里没有消息了返回nil
+func (s *ChatService) LatestMessage(msg *model.Message) (*model.Message, error) {
+	latest, err := s.msgRepo.GetMessages(msg.FromUserId, msg.ToId, msg.Type, 0, 1)
+	if err != nil || len(latest) == 0 {
+		return nil, err
+	}
+	return latest[0], nil
+}
+
+// 给会话分配下一个序号
+func (s *ChatService) NextSeq(convId string) (int64, error) {
+	return s.seqRepo.NextSeq(convId)
+}
+
+// 增量同步参数
+const (
+	DefaultSyncLimit = 100
+	MaxSyncLimit     = 500
+	MaxSyncCursors   = 100 //一次最多同步多少个会话，更多的分批请求
+	maxSyncGaps      = 100
+)
+
+// 客户端本地某个会话已经收到的最大seq
+type SyncCursor struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required"` //1-私聊 2-群聊
+	Seq      int64  `json:"seq"`
+}
+type SyncResult struct {
+	TargetId string       `json:"target_id"`
+	Type     int          `json:"type"`
+	Messages []MsgPayload `json:"messages"`
+	MaxSeq   int64        `json:"max_seq"`  //服务端当前最大seq
+	HasMore  bool         `json:"has_more"` //超过limit，需要用最后一条的seq继续拉
+	// 本次范围内服务端也不存在的seq，客户端不用再重试。
+	// 除了落库失败的seq，阅后即焚过期、已经被清理的消息也会出现在这里，
+	// 服务端不区分这两种情况，客户端统一当作“这个seq没有消息”处理，不要提示丢消息
+	Gaps []int64 `json:"gaps"`
+}
+
+// 按会话拉取seq之后的消息
+func (s *ChatService) SyncMessages(userId string, cursors []SyncCursor, limit int) ([]SyncResult, error) {
+	if limit <= 0 {
+		limit = DefaultSyncLimit
+	}
+	if limit > MaxSyncLimit {
+		limit = MaxSyncLimit
+	}
+	if len(cursors) > MaxSyncCursors {
+		return nil, errno.ErrSyncTooMany
+	}
+	results := make([]SyncResult, 0, len(cursors))
+	for _, cursor := range cursors {
+		if cursor.Type == model.MsgTypeGroup {
+			isMember, err := s.groupRepo.IsMember(cursor.TargetId, userId)
+			if err != nil {
+				return nil, err
+			}
+			if !isMember {
+				return nil, errno.ErrNotGroupMember
+			}
+		}
+		result, err := s.syncConversation(userId, cursor, limit)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+func (s *ChatService) syncConversation(userId string, cursor SyncCursor, limit int) (*SyncResult, error) {
+	convId := model.ConversationId(cursor.Type, userId, cursor.TargetId)
+	//多拉一条用来判断has_more
+	messages, err := s.msgRepo.GetMessagesAfterSeq(convId, cursor.Seq, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	maxSeq, err := s.msgRepo.GetMaxSeq(convId)
+	if err != nil {
+		return nil, err
+	}
+	result := &SyncResult{
+		TargetId: cursor.TargetId,
+		Type:     cursor.Type,
+		Messages: make([]MsgPayload, 0, len(messages)),
+		MaxSeq:   maxSeq,
+		Gaps:     []int64{},
+	}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		result.HasMore = true
+	}
+	//检测空洞：seq分配了但是落库失败的消息，以及过期被清理的阅后即焚消息
+	expected := cursor.Seq + 1
+	for _, msg := range messages {
+		for ; expected < msg.Seq && len(result.Gaps) < maxSyncGaps; expected++ {
+			result.Gaps = append(result.Gaps, expected)
+		}
+		expected = msg.Seq + 1
+		result.Messages = append(result.Messages, toMsgPayload(msg))
+	}
+	s.fillQuotes(result.Messages)
+	s.fillReactions(result.Messages)
+	return result, nil
+}
+
+// 校验回复和话题：引用的消息必须在同一个会话里；话题只在群聊里有，且不能嵌套
+// 返回引用预览和最终归属的话题根消息ID
+func (s *ChatService) ResolveReply(senderId string, chatType int, receiverId, replyTo, rootId string) (*model.QuoteInfo, string, error) {
+	convId := model.ConversationId(chatType, senderId, receiverId)
+	var quote *model.QuoteInfo
+	if replyTo != "" {
+		parent, err := s.findMessage(replyTo)
+		if err != nil {
+			return nil, "", err
+		}
+		if parent.Type != chatType || model.ConversationId(parent.Type, parent.FromUserId, parent.ToId) != convId {
+			return nil, "", errno.ErrReplyInvalid
+		}
+		if parent.Status == model.MsgStatusRecalled {
+			return nil, "", errno.ErrMessageRecalled
+		}
+		quote = model.NewQuoteInfo(parent)
+		//回复话题里的某条消息，默认还留在这个话题里
+		if rootId == "" {
+			rootId = parent.RootId
+		}
+	}
+	if rootId == "" {
+		return quote, "", nil
+	}
+	if chatType != model.MsgTypeGroup {
+		return nil, "", errno.ErrReplyInvalid
+	}
+	root, err := s.findMessage(rootId)
+	if err != nil {
+		return nil, "", err
+	}
+	if root.Type != model.MsgTypeGroup || root.ToId != receiverId || root.RootId != "" {
+		return nil, "", errno.ErrReplyInvalid
+	}
+	if root.Status == model.MsgStatusRecalled {
+		return nil, "", errno.ErrMessageRecalled
+	}
+	return quote, root.Uuid, nil
+}
+
+// 单条消息最多@多少人，100个用户ID大约4KB，要小于websocket的单帧上限(32KB)
+// 超过的返回ErrMentionInvalid，由发送流程回错误帧，不会断开连接
+const maxMentionIds = 100
+
+// 校验@：只有群聊能@，被@的必须是群成员，@所有人只有群主和管理员可以
+// 返回去重、去掉自己之后的用户ID
+func (s *ChatService) ResolveMentions(senderId string, chatType int, groupId string, userIds []string, all bool) ([]string, error) {
+	if len(userIds) == 0 && !all {
+		return nil, nil
+	}
+	if chatType != model.MsgTypeGroup || len(userIds) > maxMentionIds {
+		return nil, errno.ErrMentionInvalid
+	}
+	if all {
+		member, err := s.groupRepo.GetMember(groupId, senderId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errno.ErrNotGroupMember
+			}
+			return nil, err
+		}
+		if member.Role != model.RoleOwner && member.Role != model.RoleAdmin {
+			return nil, errno.ErrMentionDenied
+		}
+	}
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+	memberIds, err := s.groupRepo.GetMemberIDs(groupId)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(memberIds))
+	for _, memberId := range memberIds {
+		members[memberId] = true
+	}
+	seen := make(map[string]bool, len(userIds))
+	result := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		if userId == senderId || seen[userId] {
+			continue
+		}
+		if !members[userId] {
+			return nil, errno.ErrMentionInvalid
+		}
+		seen[userId] = true
+		result = append(result, userId)
+	}
+	return result, nil
+}
+
+// 未读@列表最多返回条数
+const maxUnreadMentions = 100
+
+type MentionDto struct {
+	GroupId    string `json:"group_id"`
+	MsgId      string `json:"msg_id"`
+	FromUserId string `json:"from_user_id"`
+	Seq        int64  `json:"seq"`
+	All        bool   `json:"all"` //是否是@所有人
+	Content    string `json:"content"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// 所有群里还没读到的@，最新的在前
+func (s *ChatService) GetUnreadMentions(userId string) ([]MentionDto, error) {
+	mentions, err := s.mentionRepo.ListUnread(userId, maxUnreadMentions)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make([]string, 0, len(mentions))
+	for _, mention := range mentions {
+		uuids = append(uuids, mention.MsgUuid)
+	}
+	messages, err := s.msgRepo.FindByUuids(uuids)
+	if err != nil {
+		return nil, err
+	}
+	msgMap := make(map[string]*model.Message, len(messages))
+	for _, msg := range messages {
+		msgMap[msg.Uuid] = msg
+	}
+	//同一条消息既@了我又@了所有人，只返回一次
+	added := make(map[string]bool, len(mentions))
+	result := make([]MentionDto, 0, len(mentions))
+	for _, mention := range mentions {
+		msg, ok := msgMap[mention.MsgUuid]
+		if !ok || added[mention.MsgUuid] {
+			continue
+		}
+		added[mention.MsgUuid] = true
+		result = append(result, MentionDto{
+			GroupId:    mention.GroupId,
+			MsgId:      mention.MsgUuid,
+			FromUserId: mention.FromUserId,
+			Seq:        mention.Seq,
+			All:        mention.UserId == "",
+			Content:    model.MessagePreview(msg.MediaType, msg.Content),
+			CreatedAt:  msg.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// 一次最多转发多少条消息
+const MaxForwardMessages = 100
+
+// 加载要转发的消息：必须是转发人能看到的、没有撤回的消息，按发送时间排好序
+// 阅后即焚消息不能转发，否则转出去的副本会绕过原会话的过期时间
+// 合并转发要求所有消息来自同一个会话
+func (s *ChatService) LoadForwardable(userId string, msgIds []string, merged bool) ([]*model.Message, error) {
+	if len(msgIds) == 0 || len(msgIds) > MaxForwardMessages {
+		return nil, errno.ErrForwardInvalid
+	}
+	messages, err := s.msgRepo.FindByUuids(msgIds)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		found[msg.Uuid] = true
+	}
+	for _, msgId := range msgIds {
+		if !found[msgId] {
+			return nil, errno.ErrMessageNotFound
+		}
+	}
+	var convId string
+	for i, msg := range messages {
+		if msg.Status == model.MsgStatusRecalled {
+			return nil, errno.ErrMessageRecalled
+		}
+		if msg.ExpireAt > 0 {
+			return nil, errno.ErrForwardInvalid
+		}
+		if err := s.checkParticipant(userId, msg); err != nil {
+			return nil, err
+		}
+		msgConvId := model.ConversationId(msg.Type, msg.FromUserId, msg.ToId)
+		if i == 0 {
+			convId = msgConvId
+		} else if merged && msgConvId != convId {
+			return nil, errno.ErrForwardInvalid
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].Seq < messages[j].Seq
+	})
+	return messages, nil
+}
+
+// 展开聊天记录，能看到这条消息的人才能展开
+func (s *ChatService) GetChatRecord(userId, msgId string) (*model.ChatRecord, error) {
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status == model.MsgStatusRecalled {
+		return nil, errno.ErrMessageRecalled
+	}
+	if err := s.checkParticipant(userId, msg); err != nil {
+		return nil, err
+	}
+	record := msg.ChatRecord()
+	if record == nil {
+		return nil, errno.ErrForwardInvalid
+	}
+	return record, nil
+}
+
+// 置顶/取消置顶：单聊双方都可以，群聊只有群主和管理员可以
+// changed为false说明重复置顶或者取消了没置顶的消息，不需要推送
+func (s *ChatService) PinMessage(userId, msgId string, pin bool) (*model.Message, bool, error) {
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.checkParticipant(userId, msg); err != nil {
+		return nil, false, err
+	}
+	if msg.Type == model.MsgTypeGroup {
+		member, err := s.groupRepo.GetMember(msg.ToId, userId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, false, errno.ErrNotGroupMember
+			}
+			return nil, false, err
+		}
+		if member.Role != model.RoleOwner && member.Role != model.RoleAdmin {
+			return nil, false, errno.ErrPinDenied
+		}
+	}
+	convId := model.ConversationId(msg.Type, msg.FromUserId, msg.ToId)
+	if !pin {
+		changed, err := s.pinRepo.Remove(convId, msg.Uuid)
+		return msg, changed, err
+	}
+	if msg.Status == model.MsgStatusRecalled {
+		return nil, false, errno.ErrMessageRecalled
+	}
+	changed, err := s.pinRepo.Add(&model.PinnedMessage{ConvId: convId, MsgUuid: msg.Uuid, PinnedBy: userId}, s.maxPins)
+	if errors.Is(err, repo.ErrPinLimitReached) {
+		return nil, false, errno.ErrPinLimit
+	}
+	return msg, changed, err
+}
+
+type PinDto struct {
+	Message  MsgPayload `json:"message"`
+	PinnedBy string     `json:"pinned_by"`
+	PinnedAt string     `json:"pinned_at"`
+}
+
+// 会话里的置顶消息，最近置顶的在前，已撤回的不返回
+func (s *ChatService) ListPins(userId, targetId string, chatType int) ([]PinDto, error) {
+	if chatType == model.MsgTypeGroup {
+		isMember, err := s.groupRepo.IsMember(targetId, userId)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, errno.ErrNotGroupMember
+		}
+	}
+	pins, err := s.pinRepo.List(model.ConversationId(chatType, userId, targetId))
+	if err != nil {
+		return nil, err
+	}
+	uuids := make([]string, 0, len(pins))
+	for _, pin := range pins {
+		uuids = append(uuids, pin.MsgUuid)
+	}
+	messages, err := s.msgRepo.FindByUuids(uuids)
+	if err != nil {
+		return nil, err
+	}
+	msgMap := make(map[string]*model.Message, len(messages))
+	for _, msg := range messages {
+		msgMap[msg.Uuid] = msg
+	}
+	result := make([]PinDto, 0, len(pins))
+	for _, pin := range pins {
+		msg, ok := msgMap[pin.MsgUuid]
+		if !ok || msg.Status == model.MsgStatusRecalled {
+			continue
+		}
+		result = append(result, PinDto{
+			Message:  toMsgPayload(msg),
+			PinnedBy: pin.PinnedBy,
+			PinnedAt: pin.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// 阅后即焚时长的取值范围
+const (
+	MinMessageTTL = 10
+	MaxMessageTTL = 7 * 24 * 3600
+)
+
+// 会话当前的阅后即焚时长，0为关闭
+func (s *ChatService) MessageTTL(convId string) (int64, error) {
+	return s.settingRepo.GetMessageTTL(convId)
+}
+
+// 修改阅后即焚时长：单聊双方都可以改，群聊只有群主和管理员可以
+func (s *ChatService) SetMessageTTL(userId, targetId string, chatType int, ttl int64) error {
+	if ttl != 0 && (ttl < MinMessageTTL || ttl > MaxMessageTTL) {
+		return errno.ErrTTLInvalid
+	}
+	if err := s.CheckSendPermission(userId, chatType, targetId); err != nil {
+		return err
+	}
+	if chatType == model.MsgTypeGroup {
+		member, err := s.groupRepo.GetMember(targetId, userId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errno.ErrNotGroupMember
+			}
+			return err
+		}
+		if member.Role != model.RoleOwner && member.Role != model.RoleAdmin {
+			return errno.ErrSettingDenied
+		}
+	}
+	return s.settingRepo.SetMessageTTL(model.ConversationId(chatType, userId, targetId), chatType, ttl, userId)
+}
+
+type ConversationSettingDto struct {
+	TargetId   string `json:"target_id"`
+	Type       int    `json:"type"`
+	MessageTTL int64  `json:"message_ttl"` //阅后即焚时长，单位秒，0为关闭
+}
+
+func (s *ChatService) GetConversationSetting(userId, targetId string, chatType int) (*ConversationSettingDto, error) {
+	if chatType == model.MsgTypeGroup {
+		isMember, err := s.groupRepo.IsMember(targetId, userId)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, errno.ErrNotGroupMember
+		}
+	}
+	ttl, err := s.settingRepo.GetMessageTTL(model.ConversationId(chatType, userId, targetId))
+	if err != nil {
+		return nil, err
+	}
+	return &ConversationSettingDto{TargetId: targetId, Type: chatType, MessageTTL: ttl}, nil
+}
+
+// 清理一批过期的阅后即焚消息，返回本次真正删掉的
+func (s *ChatService) PurgeExpired(limit int) ([]*model.Message, error) {
+	expired, err := s.msgRepo.FindExpired(time.Now().Unix(), limit)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+	uuids := make([]string, 0, len(expired))
+	for _, msg := range expired {
+		uuids = append(uuids, msg.Uuid)
+	}
+	purged, err := s.msgRepo.PurgeMessages(uuids)
+	if err != nil {
+		return nil, err
+	}
+	purgedSet := make(map[string]bool, len(purged))
+	for _, uuid := range purged {
+		purgedSet[uuid] = true
+	}
+	result := make([]*model.Message, 0, len(purged))
+	for _, msg := range expired {
+		if purgedSet[msg.Uuid] {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// 单个话题的分页结果
+type ThreadResult struct {
+	Root    MsgPayload   `json:"root"`
+	Replies []MsgPayload `json:"replies"`
+	HasMore bool         `json:"has_more"` //需要用最后一条的seq继续拉
+}
+
+// 按seq分页拉取话题下的回复，群成员才能看
+func (s *ChatService) GetThread(userId, rootId string, seq int64, limit int) (*ThreadResult, error) {
+	if limit <= 0 {
+		limit = DefaultSyncLimit
+	}
+	if limit > MaxSyncLimit {
+		limit = MaxSyncLimit
+	}
+	root, err := s.findMessage(rootId)
+	if err != nil {
+		return nil, err
+	}
+	if root.RootId != "" {
+		return nil, errno.ErrReplyInvalid
+	}
+	if err := s.checkParticipant(userId, root); err != nil {
+		return nil, err
+	}
+	messages, err := s.msgRepo.GetThreadMessages(root.Uuid, seq, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	result := &ThreadResult{
+		Root:    toMsgPayload(root),
+		Replies: make([]MsgPayload, 0, len(messages)),
+	}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		result.HasMore = true
+	}
+	for _, msg := range messages {
+		result.Replies = append(result.Replies, toMsgPayload(msg))
+	}
+	s.fillQuotes(result.Replies)
+	s.fillReactions(result.Replies)
+	return result, nil
+}
+
+// 已读回执：推进阅读者在会话里的已读位置，读到某条即认为之前的都读了
+func (s *ChatService) MarkMessageRead(readerId, msgId string) (*model.Message, error) {
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type == model.MsgTypeGroup {
+		if err := s.checkParticipant(readerId, msg); err != nil {
+			return nil, err
+		}
+		return msg, s.groupRepo.UpdateReadSeq(msg.ToId, readerId, msg.Seq)
+	}
+	//单聊只有接收方需要回执
+	if msg.ToId != readerId {
+		return nil, errno.ErrNotParticipant
+	}
+	return msg, s.sessionRepo.UpdateReadSeq(readerId, msg.FromUserId, msg.Seq)
+}
+
+type ReaderDto struct {
+	UserId   string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+type MessageReadersDto struct {
+	MsgId       string      `json:"msg_id"`
+	ReadCount   int         `json:"read_count"`
+	UnreadCount int         `json:"unread_count"`
+	Read        []ReaderDto `json:"read"`
+	Unread      []ReaderDto `json:"unread"`
+}
+
+// 查询一条消息哪些人读了、哪些人没读，发送者自己不算
+func (s *ChatService) GetMessageReaders(userId, msgId string) (*MessageReadersDto, error) {
+	msg, err := s.findMessage(msgId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkParticipant(userId, msg); err != nil {
+		return nil, err
+	}
+	//userId -> 是否已读
+	readState := make(map[string]bool)
+	nicknames := make(map[string]string)
+	var userIds []string
+	if msg.Type == model.MsgTypeGroup {
+		members, err := s.groupRepo.GetGroupMembers(msg.ToId)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			//消息发出之后才进群的不算
+			if member.UserId == msg.FromUserId || member.CreatedAt.After(msg.CreatedAt) {
+				continue
+			}
+			userIds = append(userIds, member.UserId)
+			readState[member.UserId] = member.ReadSeq >= msg.Seq
+			nicknames[member.UserId] = member.Nickname
+		}
+	} else {
+		readSeq, err := s.sessionRepo.GetReadSeq(msg.ToId, msg.FromUserId)
+		if err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, msg.ToId)
+		readState[msg.ToId] = readSeq >= msg.Seq
+	}
+	userMap, err := s.userRepo.FindUsersByIDs(userIds)
+	if err != nil {
+		return nil, err
+	}
+	result := &MessageReadersDto{
+		MsgId:  msg.Uuid,
+		Read:   []ReaderDto{},
+		Unread: []ReaderDto{},
+	}
+	for _, uid := range userIds {
+		reader := ReaderDto{UserId: uid, Nickname: nicknames[uid]}
+		if user, ok := userMap[uid]; ok {
+			reader.Avatar = user.Avatar
+			if reader.Nickname == "" {
+				reader.Nickname = user.Nickname
+			}
+		}
+		if readState[uid] {
+			result.Read = append(result.Read, reader)
+		} else {
+			result.Unread = append(result.Unread, reader)
+		}
+	}
+	result.ReadCount = len(result.Read)
+	result.UnreadCount = len(result.Unread)
+	return result, nil
+}
+
+// 校验富媒体消息：引用的附件必须是发送者自己上传的，地址、大小、类型以服务端记录为准
+func (s *ChatService) ValidateMedia(senderId string, mediaType int, media *model.MediaInfo) error {
+	switch mediaType {
+	case model.MediaTypeText:
+		if media != nil {
+			return errno.ErrInvalidMedia
+		}
+		return nil
+	case model.MediaTypeLocation:
+		if media == nil || media.Latitude < -90 || media.Latitude > 90 ||
+			media.Longitude < -180 || media.Longitude > 180 {
+			return errno.ErrInvalidMedia
+		}
+		return nil
+	case model.MediaTypeImage, model.MediaTypeAudio, model.MediaTypeVideo, model.MediaTypeFile:
+	default:
+		return errno.ErrInvalidMedia
+	}
+	if media == nil || media.FileId == "" {
+		return errno.ErrInvalidMedia
+	}
+	file, err := s.fileRepo.FindByUuid(media.FileId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errno.ErrFileNotFound
+		}
+		return err
+	}
+	if file.OwnerId != senderId {
+		return errno.ErrFileDenied
+	}
+	if !mimeMatches(mediaType, file.Mime) {
+		return errno.ErrInvalidMedia
+	}
+	media.Url = model.AttachmentURL(file.Uuid, false)
+	media.Size = file.Size
+	media.Mime = file.Mime
+	media.Thumbnail = ""
+	if file.ThumbKey != "" {
+		media.Thumbnail = model.AttachmentURL(file.Uuid, true)
+	}
+	if file.Width > 0 {
+		media.Width, media.Height = file.Width, file.Height
+	}
+	if media.Name == "" {
+		media.Name = file.Name
+	}
+	return nil
+}
+
+// 图片/语音/视频消息的附件类型必须对得上，文件消息不限制
+func mimeMatches(mediaType int, mimeType string) bool {
+	switch mediaType {
+	case model.MediaTypeImage:
+		return strings.HasPrefix(mimeType, "image/")
+	case model.MediaTypeAudio:
+		return strings.HasPrefix(mimeType, "audio/")
+	case model.MediaTypeVideo:
+		return strings.HasPrefix(mimeType, "video/")
+	default:
+		return true
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"my-chat/internal/model"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/util/snowflake"
+	"my-chat/pkg/zlog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// 分片上传参数
+const (
+	ChunkSize           = 5 << 20          //每片5MB，最后一片可以更小
+	UploadSessionIdle   = 24 * time.Hour   //这么久没有新分片就清理
+	UploadSweepInterval = 10 * time.Minute //清理任务的执行间隔
+	UploadClaimTTL      = 30 * time.Minute //合并大文件的最长耗时，超时占用自动释放
+	uploadSweepBatch    = 100
+)
+
+// 分片存在附件存储里，多节点部署时分片可以落在不同节点
+func chunkKey(uploadId string, index int) string {
+	return fmt.Sprintf("chunks/%s/%d", uploadId, index)
+}
+
+type UploadStatus struct {
+	UploadId   string `json:"upload_id"`
+	ChunkSize  int64  `json:"chunk_size"`
+	ChunkCount int    `json:"chunk_count"`
+	Received   []int  `json:"received"` //已收到的分片序号，从0开始
+}
+
+// 开始分片上传，返回分片大小和片数，客户端按这个切分
+func (s *UploadService) InitUpload(ownerId, fileName string, size int64, checksum string) (*UploadStatus, error) {
+	checksum = strings.ToLower(checksum)
+	if size <= 0 || len(checksum) != sha256.Size*2 {
+		return nil, errno.ErrBind
+	}
+	//具体类型要合并后才知道，这里先按最宽松的限制挡一下
+	if size > s.maxUploadSize() {
+		return nil, errno.ErrFileTooLarge
+	}
+	session := &model.UploadSession{
+		UploadId:   snowflake.GenStringID(),
+		OwnerId:    ownerId,
+		FileName:   fileName,
+		Size:       size,
+		ChunkSize:  ChunkSize,
+		ChunkCount: int((size + ChunkSize - 1) / ChunkSize),
+		Sha256:     checksum,
+		CreatedAt:  time.Now().Unix(),
+	}
+	if err := s.uploadRepo.CreateSession(session, time.Now().Add(UploadSessionIdle).Unix()); err != nil {
+		return nil, err
+	}
+	return &UploadStatus{
+		UploadId:   session.UploadId,
+		ChunkSize:  session.ChunkSize,
+		ChunkCount: session.ChunkCount,
+		Received:   []int{},
+	}, nil
+}
+
+// 上传一个分片，顺序随意，重复上传同一片会覆盖
+func (s *UploadService) UploadChunk(ownerId, uploadId string, index int, data io.Reader, size int64) error {
+	session, err := s.getUploadSession(ownerId, uploadId)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= session.ChunkCount || size != expectedChunkSize(session, index) {
+		return errno.ErrChunkInvalid
+	}
+	if err := s.store.Put(context.Background(), chunkKey(uploadId, index), data, size, "application/octet-stream"); err != nil {
+		return err
+	}
+	return s.uploadRepo.AddChunk(uploadId, index, time.Now().Add(UploadSessionIdle).Unix())
+}
+
+// 查询已收到的分片，断点续传时客户端只补传缺的
+func (s *UploadService) GetUploadStatus(ownerId, uploadId string) (*UploadStatus, error) {
+	session, err := s.getUploadSession(ownerId, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	received, err := s.uploadRepo.GetChunks(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadStatus{
+		UploadId:   session.UploadId,
+		ChunkSize:  session.ChunkSize,
+		ChunkCount: session.ChunkCount,
+		Received:   received,
+	}, nil
+}
+
+// 合并分片：按顺序拼到临时文件并校验sha256，再走普通附件的保存流程
+// 先占用上传会话，重复提交或者清理任务不会和合并同时进行
+func (s *UploadService) CompleteUpload(ownerId, uploadId string) (*AttachmentInfo, error) {
+	session, err := s.getUploadSession(ownerId, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := s.uploadRepo.Claim(uploadId, UploadClaimTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, errno.ErrUploadBusy
+	}
+	info, err := s.mergeUpload(ownerId, session)
+	if err != nil {
+		if unclaimErr := s.uploadRepo.Unclaim(uploadId); unclaimErr != nil {
+			zlog.Error("release upload claim failed", zap.String("upload_id", uploadId), zap.Error(unclaimErr))
+		}
+		return nil, err
+	}
+	s.removeUpload(session)
+	return info, nil
+}
+
+func (s *UploadService) mergeUpload(ownerId string, session *model.UploadSession) (*AttachmentInfo, error) {
+	uploadId := session.UploadId
+	received, err := s.uploadRepo.GetChunks(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	if len(received) != session.ChunkCount {
+		return nil, errno.ErrUploadPartial
+	}
+
+	tmp, err := os.CreateTemp("", "chunk-merge-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	writer := io.MultiWriter(tmp, hasher)
+	var written int64
+	for i := 0; i < session.ChunkCount; i++ {
+		n, err := s.copyChunk(writer, uploadId, i)
+		if err != nil {
+			return nil, err
+		}
+		written += n
+	}
+	if written != session.Size {
+		return nil, errno.ErrUploadPartial
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != session.Sha256 {
+		return nil, errno.ErrChecksum
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return s.saveAttachment(ownerId, session.FileName, tmp, session.Size)
+}
+
+func (s *UploadService) copyChunk(w io.Writer, uploadId string, index int) (int64, error) {
+	rc, err := s.store.Open(context.Background(), chunkKey(uploadId, index))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
+
+func (s *UploadService) getUploadSession(ownerId, uploadId string) (*model.UploadSession, error) {
+	session, err := s.uploadRepo.GetSession(uploadId)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errno.ErrUploadNotFound
+		}
+		return nil, err
+	}
+	//别人的上传会话当作不存在
+	if session.OwnerId != ownerId {
+		return nil, errno.ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// 删掉分片和会话记录
+func (s *UploadService) removeUpload(session *model.UploadSession) {
+	ctx := context.Background()
+	for i := 0; i < session.ChunkCount; i++ {
+		if err := s.store.Delete(ctx, chunkKey(session.UploadId, i)); err != nil {
+			zlog.Warn("delete chunk failed", zap.String("upload_id", session.UploadId), zap.Int("index", i), zap.Error(err))
+		}
+	}
+	if err := s.uploadRepo.DeleteSession(session.UploadId); err != nil {
+		zlog.Error("delete upload session failed", zap.String("upload_id", session.UploadId), zap.Error(err))
+	}
+}
+
+// StartUploadSweeper 定时清理长时间没有动静的分片上传，ctx取消后退出
+func (s *UploadService) StartUploadSweeper(ctx context.Context) {
+	ticker := time.NewTicker(UploadSweepInterval)
+	defer ticker.Stop()
+	zlog.Info("Upload sweeper started...")
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Info("Upload sweeper stopped")
+			return
+		case <-ticker.C:
+			s.sweepExpiredUploads()
+		}
+	}
+}
+
+func (s *UploadService) sweepExpiredUploads() {
+	uploadIds, err := s.uploadRepo.GetExpired(time.Now().Unix(), uploadSweepBatch)
+	if err != nil {
+		zlog.Error("get expired uploads failed", zap.Error(err))
+		return
+	}
+	for _, uploadId := range uploadIds {
+		//正在合并的不动，合并完会自己清理
+		claimed, err := s.uploadRepo.Claim(uploadId, UploadClaimTTL)
+		if err != nil || !claimed {
+			continue
+		}
+		session, err := s.uploadRepo.GetSession(uploadId)
+		if err != nil {
+			//会话数据已经没了，只清索引
+			_ = s.uploadRepo.DeleteSession(uploadId)
+			continue
+		}
+		s.removeUpload(session)
+	}
+	if len(uploadIds) > 0 {
+		zlog.Info("swept expired uploads", zap.Int("count", len(uploadIds)))
+	}
+}
+
+func (s *UploadService) maxUploadSize() int64 {
+	var limit int64
+	for _, size := range s.maxSize {
+		if size > limit {
+			limit = size
+		}
+	}
+	return limit
+}
+
+func expectedChunkSize(session *model.UploadSession, index int) int64 {
+	if index == session.ChunkCount-1 {
+		return session.Size - session.ChunkSize*int64(session.ChunkCount-1)
+	}
+	return session.ChunkSize
+}
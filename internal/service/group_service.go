@@ -13,12 +13,14 @@ import (
 type GroupService struct {
 	groupRepo repo.GroupRepository
 	userRepo  repo.UserRepository
+	seqRepo   repo.SeqRepository
 }
 
-func NewGroupService(groupRepo repo.GroupRepository, userRepo repo.UserRepository) *GroupService {
+func NewGroupService(groupRepo repo.GroupRepository, userRepo repo.UserRepository, seqRepo repo.SeqRepository) *GroupService {
 	return &GroupService{
 		groupRepo: groupRepo,
 		userRepo:  userRepo,
+		seqRepo:   seqRepo,
 	}
 }
 func (s *GroupService) CreateGroup(ownerId, name string) (*model.Group, error) {
@@ -29,6 +31,7 @@ func (s *GroupService) CreateGroup(ownerId, name string) (*model.Group, error) {
 		OwnerId: ownerId,
 		Notice:  "欢迎加入群聊",
 	}
+	//新群还没有消息，群主的ReadSeq从0开始即可
 	ownerMember := &model.GroupMember{
 		GroupId:  groupId,
 		UserId:   ownerId,
@@ -55,10 +58,16 @@ func (s *GroupService) JoinGroup(groupId, userId string) error {
 	if isMember {
 		return errno.New(30404, "已经是群成员，不能重复加入")
 	}
+	//入群前的消息不算未读，ReadSeq从群当前seq开始，否则历史消息和@所有人都会被当成未读
+	maxSeqs, err := s.seqRepo.CurrentSeqs([]string{groupId})
+	if err != nil {
+		return err
+	}
 	newMember := &model.GroupMember{
 		GroupId: groupId,
 		UserId:  userId,
 		Role:    model.RoleMember,
+		ReadSeq: maxSeqs[groupId],
 	}
 	return s.groupRepo.AddMember(newMember)
 }
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"my-chat/internal/model"
+	"my-chat/internal/repo"
+	"my-chat/pkg/errno"
+)
+
+// 单次最多查询的用户数
+const MaxPresenceQuery = 200
+
+type PresenceService struct {
+	presenceRepo repo.PresenceRepository
+	contactRepo  repo.ContactRepository
+}
+
+func NewPresenceService(presenceRepo repo.PresenceRepository, contactRepo repo.ContactRepository) *PresenceService {
+	return &PresenceService{
+		presenceRepo: presenceRepo,
+		contactRepo:  contactRepo,
+	}
+}
+
+// 设备上线，返回用户是否从离线变为在线
+func (s *PresenceService) Online(userId, deviceId string) (bool, error) {
+	return s.presenceRepo.AddDevice(userId, deviceId)
+}
+
+// 设备下线，返回用户是否所有设备都下线了
+func (s *PresenceService) Offline(userId, deviceId string) (bool, error) {
+	return s.presenceRepo.RemoveDevice(userId, deviceId)
+}
+
+// 心跳续期
+func (s *PresenceService) Refresh(devices map[string][]string) error {
+	return s.presenceRepo.RefreshDevices(devices)
+}
+
+// 批量查询在线状态，按请求顺序返回
+// 和推送的规则一致：对方的联系人里我是好友才能看到，陌生人和拉黑了我的用户不返回
+func (s *PresenceService) GetPresence(userId string, userIds []string) ([]*model.Presence, error) {
+	if len(userIds) > MaxPresenceQuery {
+		return nil, errno.ErrTooManyUsers
+	}
+	contacts, err := s.contactRepo.FindContactsOf(userIds, userId)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(contacts)+1)
+	var visibleIds []string
+	//自己的状态总是能看
+	for _, targetId := range userIds {
+		if targetId == userId {
+			visible[userId] = true
+			visibleIds = append(visibleIds, userId)
+			break
+		}
+	}
+	for _, contact := range contacts {
+		if contact.Type == model.ContactTypeFriend && !visible[contact.OwnerId] {
+			visible[contact.OwnerId] = true
+			visibleIds = append(visibleIds, contact.OwnerId)
+		}
+	}
+	result := make([]*model.Presence, 0, len(visibleIds))
+	if len(visibleIds) == 0 {
+		return result, nil
+	}
+	presenceMap, err := s.presenceRepo.GetPresence(visibleIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, targetId := range userIds {
+		if visible[targetId] {
+			result = append(result, presenceMap[targetId])
+		}
+	}
+	return result, nil
+}
+
+// 需要收到在线状态变化的好友，拉黑的不通知
+func (s *PresenceService) GetFriendIds(userId string) ([]string, error) {
+	contacts, err := s.contactRepo.GetContacts(userId)
+	if err != nil {
+		return nil, err
+	}
+	var friendIds []string
+	for _, contact := range contacts {
+		if contact.Type == model.ContactTypeFriend {
+			friendIds = append(friendIds, contact.TargetId)
+		}
+	}
+	return friendIds, nil
+}
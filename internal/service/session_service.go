@@ -1,26 +1,36 @@
 package service
 
 import (
+	"errors"
 	//"my-chat/internal/model"
 	"my-chat/internal/model"
 	"my-chat/internal/repo"
+	"my-chat/pkg/errno"
 	"my-chat/pkg/zlog"
 	"sort"
 
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type SessionService struct {
 	sessionRepo repo.SessionRepository
 	groupRepo   repo.GroupRepository
 	userRepo    repo.UserRepository
+	seqRepo     repo.SeqRepository
+	mentionRepo repo.MentionRepository
+	contactRepo repo.ContactRepository
 }
 
-func NewSessionService(sessionRepo repo.SessionRepository, groupRepo repo.GroupRepository, userRepo repo.UserRepository) *SessionService {
+func NewSessionService(sessionRepo repo.SessionRepository, groupRepo repo.GroupRepository, userRepo repo.UserRepository, seqRepo repo.SeqRepository,
+	mentionRepo repo.MentionRepository, contactRepo repo.ContactRepository) *SessionService {
 	return &SessionService{
 		sessionRepo: sessionRepo,
 		groupRepo:   groupRepo,
 		userRepo:    userRepo,
+		seqRepo:     seqRepo,
+		mentionRepo: mentionRepo,
+		contactRepo: contactRepo,
 	}
 }
 
@@ -32,6 +42,8 @@ type SessionDto struct {
 	LastMsg   string `json:"last_msg"`
 	LastTime  int64  `json:"last_time"`
 	UnreadCnt int    `json:"unread_cnt"`
+	Mute      bool   `json:"mute"`              //免打扰，客户端不提醒也不显示未读数，但有人@我时照常提醒
+	Mention   string `json:"mention,omitempty"` //有未读的@时为[有人@我]，读完群消息后消失
 }
 
 func (s *SessionService) GetUserSessions(userId string) ([]SessionDto, error) {
@@ -49,10 +61,14 @@ func (s *SessionService) GetUserSessions(userId string) ([]SessionDto, error) {
 				LastMsg:   v.LastMsg,
 				LastTime:  v.LastTime,
 				UnreadCnt: v.UnreadCnt,
+				Mute:      v.Mute,
 			})
 		}
 		zlog.Info("Session list hit cache",
 			zap.String("userId", userId))
+		//缓存里的未读数可能是旧的，用实时计数覆盖
+		s.fillUnread(userId, result)
+		s.fillMentions(userId, result)
 		return result, nil
 	}
 	//获取私聊会话
@@ -67,12 +83,16 @@ func (s *SessionService) GetUserSessions(userId string) ([]SessionDto, error) {
 		return nil, err
 	}
 	var friendIds []string
+	//群会话的记录只用来存免打扰之类的设置
+	groupMute := make(map[string]bool)
 	//var groupIds []string
 	//移除了groupIds的收集，直接调用了GetUserJoinedGroups，这个方法返回的就是Group结构体
 	//里面已经包含了Name， Avatar，LAstMsg，LastTime
 	for _, session := range sessions {
 		if session.Type == 1 {
 			friendIds = append(friendIds, session.TargetId)
+		} else if session.Mute == 1 {
+			groupMute[session.TargetId] = true
 		}
 	}
 	userMap, err := s.userRepo.FindUsersByIDs(friendIds)
@@ -97,6 +117,7 @@ func (s *SessionService) GetUserSessions(userId string) ([]SessionDto, error) {
 				LastMsg:   sess.LastMsg,
 				LastTime:  sess.LastTime,
 				UnreadCnt: sess.UnreadCnt,
+				Mute:      sess.Mute == 1,
 			})
 		}
 	}
@@ -110,8 +131,11 @@ func (s *SessionService) GetUserSessions(userId string) ([]SessionDto, error) {
 			LastMsg:   group.LastMsg,
 			LastTime:  group.LastTime,
 			UnreadCnt: 0,
+			Mute:      groupMute[group.Uuid],
 		})
 	}
+	s.fillUnread(userId, result)
+	s.fillMentions(userId, result)
 	//排序，将私聊与群聊混合在一起，按照时间最新的排序
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].LastTime > result[j].LastTime
@@ -127,12 +151,121 @@ func (s *SessionService) GetUserSessions(userId string) ([]SessionDto, error) {
 				LastMsg:   dto.LastMsg,
 				LastTime:  dto.LastTime,
 				UnreadCnt: dto.UnreadCnt,
+				Mute:      dto.Mute,
 			}
 			_ = s.sessionRepo.SaveSessionToCache(userId, cacheItem)
 		}
 	}()
 	return result, nil
 }
+
+// 填充实时未读数：私聊读redis计数，群聊用群最新seq减去自己的已读seq
+func (s *SessionService) fillUnread(userId string, list []SessionDto) {
+	privateCnt, err := s.sessionRepo.GetUnreadCounts(userId)
+	if err != nil {
+		zlog.Error("get unread counts failed", zap.String("userId", userId), zap.Error(err))
+	}
+	var groupIds []string
+	for _, dto := range list {
+		if dto.Type == model.MsgTypeGroup {
+			groupIds = append(groupIds, dto.TargetId)
+		}
+	}
+	var readSeqs, maxSeqs map[string]int64
+	if len(groupIds) > 0 {
+		if readSeqs, err = s.groupRepo.GetReadSeqs(userId); err != nil {
+			zlog.Error("get group read seqs failed", zap.String("userId", userId), zap.Error(err))
+		}
+		if maxSeqs, err = s.seqRepo.CurrentSeqs(groupIds); err != nil {
+			zlog.Error("get group seqs failed", zap.String("userId", userId), zap.Error(err))
+		}
+	}
+	for i := range list {
+		if list[i].Type == model.MsgTypeSingle {
+			if cnt, ok := privateCnt[list[i].TargetId]; ok {
+				list[i].UnreadCnt = cnt
+			}
+			continue
+		}
+		unread := maxSeqs[list[i].TargetId] - readSeqs[list[i].TargetId]
+		if unread < 0 {
+			unread = 0
+		}
+		list[i].UnreadCnt = int(unread)
+	}
+}
+
+// 有未读@的群会话打上标记，实时查，不进缓存
+func (s *SessionService) fillMentions(userId string, list []SessionDto) {
+	groupIds, err := s.mentionRepo.UnreadGroupIds(userId)
+	if err != nil {
+		zlog.Error("get unread mentions failed", zap.String("userId", userId), zap.Error(err))
+		return
+	}
+	for i := range list {
+		if list[i].Type == model.MsgTypeGroup && groupIds[list[i].TargetId] {
+			list[i].Mention = model.MentionMarker
+		}
+	}
+}
+
+// 设置会话免打扰，只能对自己的好友或者所在的群设置，不然会凭空生成会话
+func (s *SessionService) SetMute(userId, targetId string, chatType int, mute bool) error {
+	switch chatType {
+	case model.MsgTypeSingle:
+		if _, err := s.contactRepo.FindContact(userId, targetId); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errno.ErrNotFriend
+			}
+			return err
+		}
+	case model.MsgTypeGroup:
+		isMember, err := s.groupRepo.IsMember(targetId, userId)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return errno.ErrNotGroupMember
+		}
+	default:
+		return errno.ErrBind
+	}
+	muteVal := 0
+	if mute {
+		muteVal = 1
+	}
+	if err := s.sessionRepo.SetMute(userId, targetId, chatType, muteVal); err != nil {
+		return err
+	}
+	return s.sessionRepo.DeleteSessionCache(userId)
+}
+
+// 标记会话已读，返回已读到的seq（群聊有效）
+func (s *SessionService) MarkRead(userId, targetId string, chatType int) (int64, error) {
+	if chatType == model.MsgTypeGroup {
+		isMember, err := s.groupRepo.IsMember(targetId, userId)
+		if err != nil {
+			return 0, err
+		}
+		if !isMember {
+			return 0, errno.ErrNotGroupMember
+		}
+		maxSeqs, err := s.seqRepo.CurrentSeqs([]string{targetId})
+		if err != nil {
+			return 0, err
+		}
+		readSeq := maxSeqs[targetId]
+		if err := s.groupRepo.UpdateReadSeq(targetId, userId, readSeq); err != nil {
+			return 0, err
+		}
+		return readSeq, nil
+	}
+	if err := s.sessionRepo.ClearUnread(userId, targetId); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
 func (s *SessionService) UpsertSession(session *model.Session) error {
 
 	return s.sessionRepo.UpsertSession(session)
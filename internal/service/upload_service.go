@@ -0,0 +1,265 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"my-chat/internal/config"
+	"my-chat/internal/model"
+	"my-chat/internal/repo"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/storage"
+	"my-chat/pkg/upload"
+	"my-chat/pkg/util/snowflake"
+	"my-chat/pkg/zlog"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 附件默认参数，配置没填时使用
+const (
+	defaultThumbSize    = 256
+	defaultURLExpire    = 5 * time.Minute
+	defaultMaxImageSize = 10 << 20
+	defaultMaxAudioSize = 20 << 20
+	defaultMaxVideoSize = 200 << 20
+	defaultMaxFileSize  = 100 << 20
+)
+
+type UploadService struct {
+	attachmentRepo repo.AttachmentRepository
+	uploadRepo     repo.UploadRepository
+	msgRepo        repo.MessageRepository
+	store          storage.Storage
+	thumbSize      int
+	urlExpire      time.Duration
+	maxSize        map[string]int64 //按附件大类限制大小，单位字节
+}
+
+func NewUploadService(attachmentRepo repo.AttachmentRepository, uploadRepo repo.UploadRepository, msgRepo repo.MessageRepository,
+	store storage.Storage, cfg *config.UploadConfig, urlExpire time.Duration) *UploadService {
+	if urlExpire <= 0 {
+		urlExpire = defaultURLExpire
+	}
+	s := &UploadService{
+		attachmentRepo: attachmentRepo,
+		uploadRepo:     uploadRepo,
+		msgRepo:        msgRepo,
+		store:          store,
+		thumbSize:      defaultThumbSize,
+		urlExpire:      urlExpire,
+		maxSize: map[string]int64{
+			upload.CategoryImage: defaultMaxImageSize,
+			upload.CategoryAudio: defaultMaxAudioSize,
+			upload.CategoryVideo: defaultMaxVideoSize,
+			upload.CategoryFile:  defaultMaxFileSize,
+		},
+	}
+	if cfg == nil {
+		return s
+	}
+	if cfg.ThumbSize > 0 {
+		s.thumbSize = cfg.ThumbSize
+	}
+	for category, mb := range map[string]int64{
+		upload.CategoryImage: cfg.MaxImageSize,
+		upload.CategoryAudio: cfg.MaxAudioSize,
+		upload.CategoryVideo: cfg.MaxVideoSize,
+		upload.CategoryFile:  cfg.MaxFileSize,
+	} {
+		if mb > 0 {
+			s.maxSize[category] = mb << 20
+		}
+	}
+	return s
+}
+
+// 上传返回给客户端的附件信息，发消息时带file_id引用
+type AttachmentInfo struct {
+	FileId    string `json:"file_id"`
+	Url       string `json:"url"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Mime      string `json:"mime"`
+	MediaType int    `json:"media_type"` //建议的消息类型
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// 保存聊天附件：嗅探类型 -> 检查大小 -> 按内容哈希落盘 -> 图片生成缩略图 -> 记录上传者
+func (s *UploadService) UploadFile(ownerId string, file *multipart.FileHeader) (*AttachmentInfo, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return s.saveAttachment(ownerId, file.Filename, src, file.Size)
+}
+
+// 普通上传和分片合并后都走这里
+func (s *UploadService) saveAttachment(ownerId, fileName string, src io.ReadSeeker, size int64) (*AttachmentInfo, error) {
+	mimeType, err := upload.SniffMime(src)
+	if err != nil {
+		return nil, err
+	}
+	category := upload.Category(mimeType)
+	if size > s.maxSize[category] {
+		return nil, errno.ErrFileTooLarge
+	}
+	hash, err := upload.HashContent(src)
+	if err != nil {
+		return nil, err
+	}
+	//同一个人重复上传同一个文件，直接返回之前的记录
+	existing, err := s.attachmentRepo.FindByOwnerHash(ownerId, hash)
+	if err == nil {
+		return toAttachmentInfo(existing), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	key := upload.HashKey(hash, upload.ExtByMime(mimeType))
+	if err := s.putIfAbsent(key, src, size, mimeType); err != nil {
+		return nil, err
+	}
+	attachment := &model.Attachment{
+		Uuid:    snowflake.GenStringID(),
+		OwnerId: ownerId,
+		Name:    fileName,
+		Key:     key,
+		Mime:    mimeType,
+		Size:    size,
+		Hash:    hash,
+	}
+	if category == upload.CategoryImage {
+		s.fillImageInfo(src, attachment)
+	}
+	if err := s.attachmentRepo.Create(attachment); err != nil {
+		return nil, err
+	}
+	return toAttachmentInfo(attachment), nil
+}
+
+// 读图片宽高并生成缩略图，失败（比如解不了的格式）不影响上传本身
+func (s *UploadService) fillImageInfo(src io.ReadSeeker, attachment *model.Attachment) {
+	if _, err := src.Seek(0, 0); err != nil {
+		return
+	}
+	width, height, err := upload.ImageSize(src)
+	if err != nil {
+		zlog.Warn("decode image size failed", zap.String("hash", attachment.Hash), zap.Error(err))
+		return
+	}
+	attachment.Width, attachment.Height = width, height
+	if int64(width)*int64(height) > upload.MaxThumbnailPixels {
+		zlog.Warn("image too large, skip thumbnail",
+			zap.String("hash", attachment.Hash),
+			zap.Int("width", width),
+			zap.Int("height", height))
+		return
+	}
+
+	if _, err := src.Seek(0, 0); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := upload.MakeThumbnail(src, &buf, s.thumbSize); err != nil {
+		zlog.Warn("make thumbnail failed", zap.String("hash", attachment.Hash), zap.Error(err))
+		return
+	}
+	thumbKey := upload.HashKey(attachment.Hash, "_thumb.jpg")
+	if err := s.putIfAbsent(thumbKey, &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+		zlog.Warn("save thumbnail failed", zap.String("hash", attachment.Hash), zap.Error(err))
+		return
+	}
+	attachment.ThumbKey = thumbKey
+}
+
+// 相同内容只存一份
+func (s *UploadService) putIfAbsent(key string, r io.Reader, size int64, contentType string) error {
+	ctx := context.Background()
+	exists, err := s.store.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return s.store.Put(ctx, key, r, size, contentType)
+}
+
+// 生成附件的限时下载地址：上传者本人，或者附件所在会话的参与者才能下载
+func (s *UploadService) SignDownload(userId, fileId string, thumb bool) (string, error) {
+	attachment, err := s.attachmentRepo.FindByUuid(fileId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errno.ErrFileNotFound
+		}
+		return "", err
+	}
+	if err := s.checkDownload(userId, attachment); err != nil {
+		return "", err
+	}
+	key := attachment.Key
+	if thumb {
+		if attachment.ThumbKey == "" {
+			return "", errno.ErrFileNotFound
+		}
+		key = attachment.ThumbKey
+	}
+	return s.store.SignURL(key, s.urlExpire)
+}
+
+func (s *UploadService) checkDownload(userId string, attachment *model.Attachment) error {
+	if attachment.OwnerId == userId {
+		return nil
+	}
+	visible, err := s.msgRepo.HasVisibleFileRef(attachment.Uuid, userId)
+	if err != nil {
+		return err
+	}
+	if !visible {
+		return errno.ErrFileForbidden
+	}
+	return nil
+}
+
+func toAttachmentInfo(attachment *model.Attachment) *AttachmentInfo {
+	return &AttachmentInfo{
+		FileId:    attachment.Uuid,
+		Url:       model.AttachmentURL(attachment.Uuid, false),
+		Name:      attachment.Name,
+		Size:      attachment.Size,
+		Mime:      attachment.Mime,
+		MediaType: mediaTypeByCategory(upload.Category(attachment.Mime)),
+		Width:     attachment.Width,
+		Height:    attachment.Height,
+		Thumbnail: thumbnailURL(attachment),
+	}
+}
+
+func thumbnailURL(attachment *model.Attachment) string {
+	if attachment.ThumbKey == "" {
+		return ""
+	}
+	return model.AttachmentURL(attachment.Uuid, true)
+}
+
+func mediaTypeByCategory(category string) int {
+	switch category {
+	case upload.CategoryImage:
+		return model.MediaTypeImage
+	case upload.CategoryAudio:
+		return model.MediaTypeAudio
+	case upload.CategoryVideo:
+		return model.MediaTypeVideo
+	default:
+		return model.MediaTypeFile
+	}
+}
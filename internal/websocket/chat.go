@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"context"
+	"my-chat/internal/model"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/util/snowflake"
+	"my-chat/pkg/zlog"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 处理上行聊天消息：鉴权 -> 分配服务端ID -> 去重 -> 投递到kafka -> 回发送确认
+func (manager *ClientManager) handleChatMessage(client *Client, chatData *ChatMessageContent) {
+	//发送者以连接上的用户为准，不信任客户端带上来的send_id
+	chatData.SendId = client.UserId
+	//转发只能走转发接口
+	chatData.Forwarded = false
+	chatData.Record = nil
+	if err := manager.chatService.CheckSendPermission(client.UserId, chatData.Type, chatData.ReceiverId); err != nil {
+		zlog.Warn("chat message denied",
+			zap.String("userId", client.UserId),
+			zap.String("receiver", chatData.ReceiverId),
+			zap.Error(err))
+		manager.sendError(client, ActionChatMessage, chatData.ClientMsgId, err)
+		return
+	}
+	mentionIds, err := manager.chatService.ResolveMentions(client.UserId, chatData.Type, chatData.ReceiverId, chatData.MentionIds, chatData.MentionAll)
+	if err != nil {
+		zlog.Warn("invalid mention",
+			zap.String("userId", client.UserId),
+			zap.String("receiver", chatData.ReceiverId),
+			zap.Error(err))
+		manager.sendError(client, ActionChatMessage, chatData.ClientMsgId, err)
+		return
+	}
+	chatData.MentionIds = mentionIds
+	if chatData.MediaType == 0 {
+		chatData.MediaType = model.MediaTypeText
+	}
+	if err := manager.chatService.ValidateMedia(client.UserId, chatData.MediaType, chatData.Media); err != nil {
+		zlog.Warn("invalid media message",
+			zap.String("userId", client.UserId),
+			zap.Int("media_type", chatData.MediaType),
+			zap.Error(err))
+		manager.sendError(client, ActionChatMessage, chatData.ClientMsgId, err)
+		return
+	}
+	quote, rootId, err := manager.chatService.ResolveReply(client.UserId, chatData.Type, chatData.ReceiverId, chatData.ReplyTo, chatData.RootId)
+	if err != nil {
+		zlog.Warn("invalid reply message",
+			zap.String("userId", client.UserId),
+			zap.String("reply_to", chatData.ReplyTo),
+			zap.String("root_id", chatData.RootId),
+			zap.Error(err))
+		manager.sendError(client, ActionChatMessage, chatData.ClientMsgId, err)
+		return
+	}
+	chatData.Quote = quote
+	chatData.RootId = rootId
+
+	record := &model.SendRecord{MsgId: snowflake.GenStringID(), Timestamp: time.Now().Unix()}
+	if chatData.ClientMsgId != "" {
+		existing, ok, err := manager.chatService.ReserveClientMsg(chatData.SendId, chatData.ClientMsgId, record)
+		if err != nil {
+			//redis不可用时放行，靠数据库唯一约束兜底
+			zlog.Error("reserve client msg id failed", zap.String("client_msg_id", chatData.ClientMsgId), zap.Error(err))
+		} else if !ok {
+			zlog.Info("duplicate chat message",
+				zap.String("client_msg_id", chatData.ClientMsgId),
+				zap.String("msg_id", existing.MsgId))
+			manager.sendAck(client, chatData.ClientMsgId, existing, true)
+			return
+		}
+	}
+	chatData.Uuid = record.MsgId
+	chatData.Timestamp = record.Timestamp
+
+	if err := manager.publishChat(chatData); err != nil {
+		zlog.Error("kafka publish error", zap.Error(err))
+		//没发出去，释放占位让客户端可以重发
+		if chatData.ClientMsgId != "" {
+			_ = manager.chatService.ReleaseClientMsg(chatData.SendId, chatData.ClientMsgId)
+		}
+		manager.sendError(client, ActionChatMessage, chatData.ClientMsgId, errno.InternalServerError)
+		return
+	}
+	manager.sendAck(client, chatData.ClientMsgId, record, false)
+}
+
+// 投递到kafka，由StartConsumer落库并推送
+func (manager *ClientManager) publishChat(chatData *ChatMessageContent) error {
+	convId := model.ConversationId(chatData.Type, chatData.SendId, chatData.ReceiverId)
+	//开启了阅后即焚的会话，按发送时的设置打上过期时间，系统提示不过期
+	chatData.ExpireAt = 0
+	if chatData.MediaType != model.MediaTypeSystem {
+		ttl, err := manager.chatService.MessageTTL(convId)
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			chatData.ExpireAt = chatData.Timestamp + ttl
+		}
+	}
+	message, err := NewMessage(ActionChatMessage, chatData)
+	if err != nil {
+		return err
+	}
+	//同一会话用同一个key，落到同一分区里按顺序消费
+	return manager.mqClient.Publish(context.Background(), []byte(convId), message)
+}
+
+func (manager *ClientManager) sendAck(client *Client, clientMsgId string, record *model.SendRecord, duplicate bool) {
+	jsonBytes, err := NewMessage(ActionSendAck, &SendAckContent{
+		ClientMsgId: clientMsgId,
+		MsgId:       record.MsgId,
+		Timestamp:   record.Timestamp,
+		Duplicate:   duplicate,
+	})
+	if err != nil {
+		zlog.Error("marshal send ack failed", zap.Error(err))
+		return
+	}
+	manager.sendToClient(client, jsonBytes)
+}
+
+// 回错误帧给发送者
+func (manager *ClientManager) sendError(client *Client, action Action, clientMsgId string, err error) {
+	code, message := errno.Decode(err)
+	jsonBytes, marshalErr := NewMessage(ActionError, &ErrorContent{
+		Action:      action,
+		Code:        code,
+		Message:     message,
+		ClientMsgId: clientMsgId,
+	})
+	if marshalErr != nil {
+		zlog.Error("marshal error frame failed", zap.Error(marshalErr))
+		return
+	}
+	manager.sendToClient(client, jsonBytes)
+}
@@ -13,16 +13,22 @@ const (
 	writeWait      = 10 * time.Second //写超时
 	pongWait       = 60 * time.Second //心跳超时
 	PingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	maxMessageSize = 32 << 10 //单帧上限，要装得下最大的同步请求和带@列表的消息
 )
 
 // Client代表一个WebSocket连接用户
 type Client struct {
-	Manager       *ClientManager  //客户端管理器，读到消息后广播， 断开注销
+	Manager       *ClientManager  //客户端管理器，读到消息后分发， 断开注销
 	Conn          *websocket.Conn //实际的ws连接
 	UserId        string          //用户ID，这个连接属于谁
+	DeviceId      string          //设备ID，同一用户多端登录时区分连接
+	DeviceType    string          //设备类型 web/ios/android/desktop
 	Send          chan []byte     //发送缓冲通道
 	HeartbeatTime int64
+
+	//临时事件限流，只在这个连接的读协程里读写
+	ephemeralWindow int64
+	ephemeralCount  int
 }
 
 // ReadPump负责从WebSocket连接中读取消息，检查客户端是不是活着
@@ -64,7 +70,8 @@ func (c *Client) ReadPump() {
 				continue
 			}
 		}
-		c.Manager.Broadcast <- message
+		//在连接自己的读协程里处理，查库和写kafka不会卡住其他连接，同一连接的消息仍然按顺序处理
+		c.Manager.dispatch(&ClientMessage{Client: c, Data: message})
 	}
 }
 
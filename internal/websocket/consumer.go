@@ -3,11 +3,14 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"my-chat/internal/model"
 	"my-chat/pkg/util/snowflake"
 	"my-chat/pkg/zlog"
 	"time"
 
+	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
@@ -15,124 +18,435 @@ import (
 const (
 	BatchSize    = 100
 	BatchTimeout = 1 * time.Second
+
+	ConsumeMaxRetry      = 3                      //分配seq、逐条插入的最大尝试次数
+	ConsumeRetryBackoff  = 200 * time.Millisecond //重试间隔基数，每次翻倍
+	DeadLetterMaxBackoff = 30 * time.Second       //写死信失败后的最大重试间隔，写不进去就一直重试
+)
+
+// 并发消费参数
+const (
+	ConsumerWorkers = 16  //消费worker数，同一会话只会进一个worker
+	WorkerQueueSize = 256 //每个worker的缓冲
 )
 
 // StartConsumer 启动消费者
+// 生产端按会话ID做分区key，同一会话的消息在同一分区内有序；
+// 这里再按key哈希分给固定的worker，不同会话并发处理，同一会话始终串行。
+// offset在消息落库（或进入死信队列）之后才提交，进程挂掉重启会从未提交处重新消费
 func (manager *ClientManager) StartConsumer() {
-	zlog.Info("Kafka Consumer Started...")
-	//msgChan := make(chan []byte, 256)
+	zlog.Info("Kafka Consumer Started...", zap.Int("workers", ConsumerWorkers))
+	workers := make([]chan kafka.Message, ConsumerWorkers)
+	for i := range workers {
+		workers[i] = make(chan kafka.Message, WorkerQueueSize)
+		go manager.consumeWorker(workers[i])
+	}
 	go func() {
 		for {
-			m, err := manager.mqClient.Reader.ReadMessage(context.Background())
+			m, err := manager.mqClient.FetchMessage(context.Background())
 			if err != nil {
 				zlog.Error("Kafka.Reader Error", zap.Error(err))
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
-			var kafkaMsg Message
-			if err := json.Unmarshal(m.Value, &kafkaMsg); err != nil {
-				zlog.Error("Kafka.Msg Unmarshal Error", zap.Error(err))
-				continue
+			manager.offsets.Track(m)
+			workers[workerIndex(m.Key, ConsumerWorkers)] <- m
+		}
+	}()
+}
+
+// 同一个key永远落到同一个worker
+func workerIndex(key []byte, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// 攒够BatchSize条或者等了BatchTimeout就落一次库
+func (manager *ClientManager) consumeWorker(queue <-chan kafka.Message) {
+	batch := make([]*consumedMessage, 0, BatchSize)
+	timer := time.NewTimer(BatchTimeout)
+	defer timer.Stop()
+	flush := func() {
+		if len(batch) > 0 {
+			manager.flushBatch(batch)
+			batch = make([]*consumedMessage, 0, BatchSize)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(BatchTimeout)
+	}
+	for {
+		select {
+		case m, ok := <-queue:
+			if !ok {
+				flush()
+				return
 			}
-			if kafkaMsg.Action != ActionChatMessage {
+			msg, err := manager.prepareChatMessage(m)
+			if err != nil {
+				manager.deadLetter(m, err)
 				continue
 			}
-			var chatData ChatMessageContent
-			if err := json.Unmarshal(kafkaMsg.Content, &chatData); err != nil {
-				zlog.Error("Unmarshal Chat data failed", zap.Error(err))
+			if msg == nil {
+				//不需要处理的消息直接确认
+				manager.commitOffset(m)
 				continue
 			}
-
-			// 4. 【核心步骤】生成 ID 并 落库 (DB Persistence)
-			// 确保消息有 ID，如果没有则生成一个 (防止旧版本消息导致空 ID)
-			if chatData.Uuid == "" {
-				chatData.Uuid = snowflake.GenStringID()
+			batch = append(batch, msg)
+			if len(batch) >= BatchSize {
+				flush()
 			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
 
-			msgModel := &model.Message{
-				Uuid:       chatData.Uuid,
-				FromUserId: chatData.SendId,
-				ToId:       chatData.ReceiverId,
-				Content:    chatData.Content,
-				Type:       chatData.Type,
-				MediaType:  1, // 暂时写死文本，后续可在 ChatMessageContent 中透传
-			}
+// 解析好、分配了seq、等待落库的消息
+type consumedMessage struct {
+	raw       kafka.Message
+	chatData  *ChatMessageContent
+	msgModel  *model.Message
+	err       error //落库最终失败的原因
+	duplicate bool  //之前已经落过库，不再推送
+}
 
-			// 同步写入 MySQL
-			err = manager.chatService.InsertMessage(msgModel)
-			if err != nil {
-				// 【重要】落库失败处理
-				// 这是一个严重问题，意味着消息丢了。
-				// 生产环境通常会：1. 重试 N 次  2. 放入死信队列 (DLQ)
-				// 这里为了简化，我们记录 Error 日志，并且跳过后续推送（保证数据一致性：没落库就不让用户看见）
-				zlog.Error("Insert Message to DB failed!!!",
-					zap.String("uuid", msgModel.Uuid),
-					zap.Error(err))
-				continue
-			}
+// 整批查重、分配seq、落库，成功后再按顺序更新会话、推送，没落库的消息不会被用户看到，转进死信队列
+func (manager *ClientManager) flushBatch(batch []*consumedMessage) {
+	manager.markDuplicates(batch)
+	var pending []*consumedMessage
+	for _, msg := range batch {
+		if msg.duplicate {
+			continue
+		}
+		if msg.err = manager.allocSeq(msg); msg.err == nil {
+			pending = append(pending, msg)
+		}
+	}
+	manager.persistBatch(pending)
+	for _, msg := range batch {
+		if msg.err != nil {
+			manager.deadLetter(msg.raw, msg.err)
+			continue
+		}
+		if msg.duplicate {
+			manager.commitOffset(msg.raw)
+			continue
+		}
+		manager.deliverChatMessage(msg.chatData)
+		manager.commitOffset(msg.raw)
+	}
+}
 
-			// ---------------------------------------------------------
-			// 程序执行到这里，说明消息已经安全躺在 MySQL 里了。
-			// 下面的操作（Session更新、推送）即使失败，用户也可以通过拉取历史记录看到消息。
-			// ---------------------------------------------------------
-
-			// 5. 更新 Session (会话列表)
-			// 直接复用你原来的逻辑，但放在了落库之后
-			currentTs := time.Now().Unix()
-			if chatData.Type == 1 {
-				// 私聊：更新发送者会话
-				_ = manager.sessionRepo.UpsertSession(&model.Session{
-					UserId:    chatData.SendId,
-					TargetId:  chatData.ReceiverId,
-					Type:      1,
-					LastMsg:   chatData.Content,
-					LastTime:  currentTs,
-					UnreadCnt: 0,
-				})
-				_ = manager.sessionRepo.DeleteSessionCache(chatData.SendId)
-
-				// 私聊：更新接收者会话
-				_ = manager.sessionRepo.UpsertSession(&model.Session{
-					UserId:    chatData.ReceiverId,
-					TargetId:  chatData.SendId,
-					Type:      1,
-					LastMsg:   chatData.Content,
-					LastTime:  currentTs,
-					UnreadCnt: 1, // 接收者未读 +1 (这里简单逻辑先写死 1，更完善的是查出来+1 或者 redis incr)
-				})
-				_ = manager.sessionRepo.DeleteSessionCache(chatData.ReceiverId)
-
-			} else if chatData.Type == 2 {
-				// 群聊：更新群信息的 LastMsg
-				err := manager.groupRepo.UpdateGroupLastMsg(chatData.ReceiverId, "群消息:"+chatData.Content, currentTs)
-				if err != nil {
-					zlog.Error("update group last msg failed", zap.Error(err))
-				}
-				// 注意：群聊没有给每个成员更新 Session 表，因为那会造成写扩散。
-				// 通常群聊列表的 LastMsg 都是直接查群信息的，或者用户上线时拉取。
-			}
+// kafka重投的消息之前已经落过库，整批查一次，在分配seq之前跳过，免得白白消耗seq
+// 查询失败就当作都不重复，唯一索引兜底
+func (manager *ClientManager) markDuplicates(batch []*consumedMessage) {
+	models := make([]*model.Message, 0, len(batch))
+	for _, msg := range batch {
+		models = append(models, msg.msgModel)
+	}
+	duplicates, err := manager.chatService.FindDuplicates(models)
+	if err != nil {
+		zlog.Warn("find duplicate messages failed", zap.Int("size", len(batch)), zap.Error(err))
+		return
+	}
+	for i, msg := range batch {
+		if duplicates[i] {
+			zlog.Info("skip duplicate message", zap.String("uuid", msg.msgModel.Uuid))
+			msg.duplicate = true
+		}
+	}
+}
 
-			// 6. WebSocket 广播 (Push)
-			zlog.Info("Consumer处理消息成功，准备推送",
-				zap.String("uuid", chatData.Uuid),
-				zap.String("sender", chatData.SendId),
-				zap.String("receiver", chatData.ReceiverId))
-
-			// 准备推送的数据
-			jsonBytes, _ := json.Marshal(chatData)
-
-			if chatData.Type == 1 {
-				// 私聊：推给接收方和发送方（多端同步）
-				manager.sendToUser(chatData.ReceiverId, jsonBytes)
-				manager.sendToUser(chatData.SendId, jsonBytes)
-			} else if chatData.Type == 2 {
-				// 群聊：推给所有群成员
-				memberIds, _ := manager.chatService.GetGroupMemberIDs(chatData.ReceiverId)
-				for _, memberId := range memberIds {
-					manager.sendToUser(memberId, jsonBytes)
-				}
-			}
+// 分配会话内序号，客户端据此发现丢消息并增量同步；redis抖动按退避重试
+func (manager *ClientManager) allocSeq(msg *consumedMessage) error {
+	var seq int64
+	err := retryWithBackoff(func() error {
+		var err error
+		seq, err = manager.chatService.NextSeq(msg.msgModel.ConvId)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("alloc seq: %w", err)
+	}
+	msg.msgModel.Seq = seq
+	msg.chatData.Seq = seq
+	return nil
+}
+
+// 一个事务写入整批，失败时退回逐条插入，最终失败的记在msg.err上
+func (manager *ClientManager) persistBatch(batch []*consumedMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	models := make([]*model.Message, 0, len(batch))
+	for _, msg := range batch {
+		models = append(models, msg.msgModel)
+	}
+	err := manager.chatService.BatchSave(models)
+	if err == nil {
+		return
+	}
+	zlog.Warn("batch insert failed, fallback to single insert",
+		zap.Int("size", len(batch)),
+		zap.Error(err))
+	for _, msg := range batch {
+		msg.duplicate, msg.err = manager.insertWithRetry(msg.msgModel)
+	}
+}
+
+// 单条插入，失败按退避重试几次；撞上唯一约束说明是重复消息，直接跳过
+func (manager *ClientManager) insertWithRetry(msgModel *model.Message) (bool, error) {
+	var err error
+	for attempt := 0; attempt < ConsumeMaxRetry; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ConsumeRetryBackoff * time.Duration(1<<(attempt-1)))
 		}
-	}()
+		if err = manager.chatService.InsertMessage(msgModel); err == nil {
+			return false, nil
+		}
+		if existing, ok := manager.chatService.FindDuplicate(msgModel); ok {
+			zlog.Info("skip duplicate message",
+				zap.String("uuid", msgModel.Uuid),
+				zap.String("existing", existing.Uuid))
+			return true, nil
+		}
+	}
+	zlog.Error("Insert Message to DB failed!!!",
+		zap.String("uuid", msgModel.Uuid),
+		zap.Error(err))
+	return false, err
+}
+
+// 处理失败的消息写入死信队列后再提交offset
+// 死信写不进去就一直退避重试，不能跳过：跳过的消息会卡在分区的inflight队头，后面的offset全都提交不了
+func (manager *ClientManager) deadLetter(m kafka.Message, cause error) {
+	backoff := ConsumeRetryBackoff
+	for {
+		err := manager.mqClient.PublishDeadLetter(context.Background(), m, cause)
+		if err == nil {
+			zlog.Warn("message moved to dead letter queue",
+				zap.Int("partition", m.Partition),
+				zap.Int64("offset", m.Offset),
+				zap.NamedError("cause", cause))
+			manager.commitOffset(m)
+			return
+		}
+		zlog.Error("publish dead letter failed, retrying",
+			zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset),
+			zap.NamedError("cause", cause),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > DeadLetterMaxBackoff {
+			backoff = DeadLetterMaxBackoff
+		}
+	}
+}
+
+// 按退避重试临时性的失败（比如redis抖动），重试完还失败才返回错误
+func retryWithBackoff(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < ConsumeMaxRetry; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ConsumeRetryBackoff * time.Duration(1<<(attempt-1)))
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// 标记处理完成，前面的消息都处理完了才真正提交
+func (manager *ClientManager) commitOffset(m kafka.Message) {
+	commit, ok := manager.offsets.Done(m)
+	if !ok {
+		return
+	}
+	if err := manager.mqClient.CommitMessages(context.Background(), commit); err != nil {
+		zlog.Error("commit offset failed",
+			zap.Int("partition", commit.Partition),
+			zap.Int64("offset", commit.Offset),
+			zap.Error(err))
+	}
+}
 
+// 解析消息，seq在落库前整批分配，返回nil, nil表示不需要处理，返回error表示要进死信队列
+func (manager *ClientManager) prepareChatMessage(m kafka.Message) (*consumedMessage, error) {
+	var kafkaMsg Message
+	if err := json.Unmarshal(m.Value, &kafkaMsg); err != nil {
+		return nil, fmt.Errorf("unmarshal message: %w", err)
+	}
+	if kafkaMsg.Action != ActionChatMessage {
+		return nil, nil
+	}
+	var chatData ChatMessageContent
+	if err := json.Unmarshal(kafkaMsg.Content, &chatData); err != nil {
+		return nil, fmt.Errorf("unmarshal chat data: %w", err)
+	}
+
+	// 4. 【核心步骤】生成 ID 并 落库 (DB Persistence)
+	// ID和时间由网关分配，这里只兜底升级前积压在kafka里的旧消息
+	if chatData.Uuid == "" {
+		chatData.Uuid = snowflake.GenStringID()
+	}
+	if chatData.Timestamp == 0 {
+		chatData.Timestamp = time.Now().Unix()
+	}
+
+	convId := model.ConversationId(chatData.Type, chatData.SendId, chatData.ReceiverId)
+	msgModel := &model.Message{
+		Uuid:       chatData.Uuid,
+		FromUserId: chatData.SendId,
+		ToId:       chatData.ReceiverId,
+		ConvId:     convId,
+		Content:    chatData.Content,
+		Type:       chatData.Type,
+		MediaType:  chatData.MediaType,
+		ReplyTo:    chatData.ReplyTo,
+		RootId:     chatData.RootId,
+		MentionIds: model.EncodeMentionIds(chatData.MentionIds),
+		MentionAll: chatData.MentionAll,
+		Forwarded:  chatData.Forwarded,
+		ExpireAt:   chatData.ExpireAt,
+	}
+	if msgModel.MediaType == 0 {
+		msgModel.MediaType = model.MediaTypeText
+	}
+	if chatData.Media != nil {
+		extra, err := json.Marshal(chatData.Media)
+		if err != nil {
+			return nil, fmt.Errorf("marshal media: %w", err)
+		}
+		msgModel.Extra = string(extra)
+		msgModel.Url = chatData.Media.Url
+		msgModel.PicUrl = chatData.Media.Thumbnail
+		msgModel.FileId = chatData.Media.FileId
+	}
+	if chatData.Record != nil {
+		extra, err := json.Marshal(chatData.Record)
+		if err != nil {
+			return nil, fmt.Errorf("marshal chat record: %w", err)
+		}
+		msgModel.Extra = string(extra)
+		//完整快照只落库，推送只带摘要
+		chatData.Record = chatData.Record.Summary()
+	}
+	msgModel.CreatedAt = time.Unix(chatData.Timestamp, 0)
+	if chatData.ClientMsgId != "" {
+		msgModel.ClientMsgId = &chatData.ClientMsgId
+	}
+
+	return &consumedMessage{raw: m, chatData: &chatData, msgModel: msgModel}, nil
+}
+
+// 落库之后：更新会话 -> 推送
+func (manager *ClientManager) deliverChatMessage(chatData *ChatMessageContent) {
+	// ---------------------------------------------------------
+	// 程序执行到这里，说明消息已经安全躺在 MySQL 里了。
+	// 下面的操作（Session更新、推送）即使失败，用户也可以通过拉取历史记录看到消息。
+	// ---------------------------------------------------------
+
+	// 5. 更新 Session (会话列表)
+	// 直接复用你原来的逻辑，但放在了落库之后
+	currentTs := time.Now().Unix()
+	preview := model.MessagePreview(chatData.MediaType, chatData.Content)
+	if chatData.Type == 1 {
+		// 私聊：更新发送者会话
+		_ = manager.sessionRepo.UpsertSession(&model.Session{
+			UserId:    chatData.SendId,
+			TargetId:  chatData.ReceiverId,
+			Type:      1,
+			LastMsg:   preview,
+			LastTime:  currentTs,
+			UnreadCnt: 0,
+		})
+		// 发消息说明已经看过这个会话了
+		_ = manager.sessionRepo.ClearUnread(chatData.SendId, chatData.ReceiverId)
+		_ = manager.sessionRepo.DeleteSessionCache(chatData.SendId)
+
+		// 私聊：更新接收者会话
+		_ = manager.sessionRepo.UpsertSession(&model.Session{
+			UserId:    chatData.ReceiverId,
+			TargetId:  chatData.SendId,
+			Type:      1,
+			LastMsg:   preview,
+			LastTime:  currentTs,
+			UnreadCnt: 0,
+		})
+		// 接收者未读 +1，mysql和redis都是原子自增
+		if err := manager.sessionRepo.IncrUnread(chatData.ReceiverId, chatData.SendId); err != nil {
+			zlog.Error("incr unread failed", zap.Error(err))
+		}
+		_ = manager.sessionRepo.DeleteSessionCache(chatData.ReceiverId)
+
+	} else if chatData.Type == 2 {
+		// 群聊：更新群信息的 LastMsg
+		err := manager.groupRepo.UpdateGroupLastMsg(chatData.ReceiverId, "群消息:"+preview, currentTs)
+		if err != nil {
+			zlog.Error("update group last msg failed", zap.Error(err))
+		}
+		// 注意：群聊没有给每个成员更新 Session 表，因为那会造成写扩散。
+		// 通常群聊列表的 LastMsg 都是直接查群信息的，或者用户上线时拉取。
+		// 未读数 = 群最新seq - 成员已读seq，发送者自己发的消息直接算已读
+		if err := manager.groupRepo.UpdateReadSeq(chatData.ReceiverId, chatData.SendId, chatData.Seq); err != nil {
+			zlog.Error("update group read seq failed", zap.Error(err))
+		}
+	}
+
+	// 6. WebSocket 广播 (Push)
+	zlog.Info("Consumer处理消息成功，准备推送",
+		zap.String("uuid", chatData.Uuid),
+		zap.String("sender", chatData.SendId),
+		zap.String("receiver", chatData.ReceiverId))
+
+	// 准备推送的数据
+	jsonBytes, _ := json.Marshal(chatData)
+	// 先记下待确认，再推送，避免ACK比记录先到
+	manager.trackPending(chatData.Type, chatData.SendId, chatData.ReceiverId, chatData.Uuid, jsonBytes)
+
+	// 私聊：推给接收方和发送方（多端同步）；群聊：推给所有群成员
+	manager.pushToConversation(chatData.Type, chatData.SendId, chatData.ReceiverId, jsonBytes)
+	manager.notifyMentions(chatData)
+}
+
+// 给被@的人单独推一条提醒，客户端据此在免打扰的会话里也能提示
+func (manager *ClientManager) notifyMentions(chatData *ChatMessageContent) {
+	if chatData.Type != model.MsgTypeGroup || (len(chatData.MentionIds) == 0 && !chatData.MentionAll) {
+		return
+	}
+	userIds := chatData.MentionIds
+	if chatData.MentionAll {
+		memberIds, err := manager.chatService.GetGroupMemberIDs(chatData.ReceiverId)
+		if err != nil {
+			zlog.Error("get group members for mention failed", zap.Error(err))
+			return
+		}
+		userIds = make([]string, 0, len(memberIds))
+		for _, memberId := range memberIds {
+			if memberId != chatData.SendId {
+				userIds = append(userIds, memberId)
+			}
+		}
+	}
+	jsonBytes, err := NewMessage(ActionMention, &MentionContent{
+		MsgId:      chatData.Uuid,
+		GroupId:    chatData.ReceiverId,
+		FromUserId: chatData.SendId,
+		Seq:        chatData.Seq,
+		All:        chatData.MentionAll,
+		Content:    model.MessagePreview(chatData.MediaType, chatData.Content),
+	})
+	if err != nil {
+		zlog.Error("marshal mention event failed", zap.Error(err))
+		return
+	}
+	manager.sendToUsers(userIds, jsonBytes)
 }
@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/zlog"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 可靠投递参数
+const (
+	DefaultAckTimeout    = 5 * time.Second
+	DefaultMaxRetransmit = 5
+	RetransmitInterval   = 1 * time.Second  //扫描到期消息的间隔
+	MaxRetransmitBackoff = 60 * time.Second //退避上限
+)
+
+// 记录需要接收方确认的消息，发送方自己的回显不需要ACK
+// 按用户记录，多端登录时任一设备确认即可，其余设备靠seq增量同步补齐
+func (manager *ClientManager) trackPending(chatType int, sendId, receiverId, msgId string, payload []byte) {
+	var receivers []string
+	for _, userId := range manager.conversationMembers(chatType, sendId, receiverId) {
+		if userId != sendId {
+			receivers = append(receivers, userId)
+		}
+	}
+	nextRetry := time.Now().Add(manager.ackTimeout).Unix()
+	if err := manager.pendingRepo.AddPending(msgId, payload, receivers, nextRetry); err != nil {
+		zlog.Error("save pending failed", zap.String("msg_id", msgId), zap.Error(err))
+	}
+}
+
+// StartRetransmit 定时给连在本节点的用户重传超时未确认的消息
+func (manager *ClientManager) StartRetransmit() {
+	ticker := time.NewTicker(RetransmitInterval)
+	defer ticker.Stop()
+	zlog.Info("Retransmit checker started...")
+	for range ticker.C {
+		now := time.Now()
+		for _, userId := range manager.onlineUserIds() {
+			entries, err := manager.pendingRepo.GetDuePending(userId, now.Unix())
+			if err != nil {
+				zlog.Error("get due pending failed", zap.String("user_id", userId), zap.Error(err))
+				continue
+			}
+			for _, entry := range entries {
+				manager.retransmit(userId, entry, now)
+			}
+		}
+	}
+}
+
+func (manager *ClientManager) retransmit(userId string, entry *model.PendingDelivery, now time.Time) {
+	manager.sendLocal(userId, []byte(entry.Payload))
+	entry.Attempts++
+	//在线重传次数用完就不再定时重传，等下次上线再补发
+	var retryAt int64
+	if entry.Attempts < manager.maxRetransmit {
+		retryAt = now.Add(manager.retransmitBackoff(entry.Attempts)).Unix()
+	}
+	updated, err := manager.pendingRepo.UpdatePending(userId, entry.MsgId, entry.Attempts, retryAt)
+	if err != nil {
+		zlog.Error("update pending failed", zap.String("user_id", userId), zap.Error(err))
+		return
+	}
+	if updated && retryAt == 0 {
+		zlog.Warn("retransmit limit reached",
+			zap.String("user_id", userId),
+			zap.String("msg_id", entry.MsgId))
+	}
+}
+
+// 指数退避：ackTimeout * 2^attempts，最多60秒
+func (manager *ClientManager) retransmitBackoff(attempts int) time.Duration {
+	backoff := manager.ackTimeout
+	for i := 0; i < attempts && backoff < MaxRetransmitBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxRetransmitBackoff {
+		backoff = MaxRetransmitBackoff
+	}
+	return backoff
+}
+
+// 用户上线后按消息顺序补发所有未确认的消息，并重新开始计时
+func (manager *ClientManager) redeliverPending(userId string) {
+	entries, err := manager.pendingRepo.GetAllPending(userId)
+	if err != nil {
+		zlog.Error("get pending failed", zap.String("user_id", userId), zap.Error(err))
+		return
+	}
+	//雪花ID越大越新，先比长度再比字典序
+	sort.Slice(entries, func(i, j int) bool {
+		if len(entries[i].MsgId) != len(entries[j].MsgId) {
+			return len(entries[i].MsgId) < len(entries[j].MsgId)
+		}
+		return entries[i].MsgId < entries[j].MsgId
+	})
+	nextRetry := time.Now().Add(manager.ackTimeout).Unix()
+	for _, entry := range entries {
+		manager.sendLocal(userId, []byte(entry.Payload))
+		//补发期间收到ACK的不会被写回去
+		if _, err := manager.pendingRepo.UpdatePending(userId, entry.MsgId, 0, nextRetry); err != nil {
+			zlog.Error("update pending failed", zap.String("user_id", userId), zap.Error(err))
+		}
+	}
+	if len(entries) > 0 {
+		zlog.Info("redeliver pending messages",
+			zap.String("user_id", userId),
+			zap.Int("count", len(entries)))
+	}
+}
+
+func (manager *ClientManager) onlineUserIds() []string {
+	manager.rwLock.RLock()
+	defer manager.rwLock.RUnlock()
+	userIds := make([]string, 0, len(manager.Clients))
+	for userId := range manager.Clients {
+		userIds = append(userIds, userId)
+	}
+	return userIds
+}
@@ -0,0 +1,37 @@
+package websocket
+
+// 设备类型，连接时由客户端带上
+const (
+	DeviceWeb     = "web"
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceDesktop = "desktop"
+)
+
+// 设备类别，互斥登录策略按类别配置，比如同一时间只允许一台手机在线
+const (
+	DeviceClassWeb     = "web"
+	DeviceClassMobile  = "mobile"
+	DeviceClassDesktop = "desktop"
+)
+
+// 不认识的设备类型统一当成web
+func NormalizeDeviceType(deviceType string) string {
+	switch deviceType {
+	case DeviceWeb, DeviceIOS, DeviceAndroid, DeviceDesktop:
+		return deviceType
+	default:
+		return DeviceWeb
+	}
+}
+
+func DeviceClass(deviceType string) string {
+	switch deviceType {
+	case DeviceIOS, DeviceAndroid:
+		return DeviceClassMobile
+	case DeviceDesktop:
+		return DeviceClassDesktop
+	default:
+		return DeviceClassWeb
+	}
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// 处理编辑请求：改库 -> 更新会话预览 -> 推送edited事件
+func (manager *ClientManager) handleEdit(client *Client, editData *EditContent) {
+	msg, err := manager.chatService.EditMessage(client.UserId, editData.MsgId, editData.Content)
+	if err != nil {
+		zlog.Warn("edit message failed",
+			zap.String("msg_id", editData.MsgId),
+			zap.String("operator", client.UserId),
+			zap.Error(err))
+		manager.sendError(client, ActionEdit, "", err)
+		return
+	}
+	manager.NotifyEdited(msg)
+}
+
+// NotifyEdited 编辑成功后同步预览并推给会话所有参与者，HTTP接口编辑也走这里
+func (manager *ClientManager) NotifyEdited(msg *model.Message) {
+	manager.updateLatestPreview(msg, msg.Content)
+
+	event := EditContent{
+		MsgId:      msg.Uuid,
+		Content:    msg.Content,
+		EditorId:   msg.FromUserId,
+		SendId:     msg.FromUserId,
+		ReceiverId: msg.ToId,
+		Type:       msg.Type,
+		EditedAt:   msg.EditedAt,
+	}
+	jsonBytes, err := NewMessage(ActionEdited, &event)
+	if err != nil {
+		zlog.Error("marshal edited event failed", zap.Error(err))
+		return
+	}
+	manager.pushToConversation(msg.Type, msg.FromUserId, msg.ToId, jsonBytes)
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"fmt"
+	"my-chat/pkg/zlog"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 临时事件参数
+const (
+	EphemeralRateLimit = 5               //每个连接每秒最多转发的临时事件数
+	TypingTTL          = 6 * time.Second //没收到stop时自动过期
+)
+
+// 固定窗口限流，超过的事件直接丢弃
+func (c *Client) allowEphemeral(now time.Time) bool {
+	window := now.Unix()
+	if c.ephemeralWindow != window {
+		c.ephemeralWindow = window
+		c.ephemeralCount = 0
+	}
+	if c.ephemeralCount >= EphemeralRateLimit {
+		return false
+	}
+	c.ephemeralCount++
+	return true
+}
+
+// 处理正在输入：鉴权后直接转给会话里其他在线的人
+func (manager *ClientManager) handleTyping(client *Client, action Action, typingData *TypingContent) {
+	if !client.allowEphemeral(time.Now()) {
+		zlog.Debug("ephemeral event rate limited", zap.String("userId", client.UserId))
+		return
+	}
+	if err := manager.chatService.CheckSendPermission(client.UserId, typingData.Type, typingData.ReceiverId); err != nil {
+		zlog.Debug("typing event denied",
+			zap.String("userId", client.UserId),
+			zap.String("receiver", typingData.ReceiverId),
+			zap.Error(err))
+		return
+	}
+	typingData.SendId = client.UserId
+	key := fmt.Sprintf("%s:%d:%s", client.UserId, typingData.Type, typingData.ReceiverId)
+	if action == ActionTypingStart {
+		typingData.ExpireIn = int(TypingTTL / time.Second)
+		//超时没收到stop，服务端替客户端发一个stop
+		expired := *typingData
+		expired.ExpireIn = 0
+		manager.resetTypingTimer(key, func() {
+			manager.relayEphemeral(ActionTypingStop, &expired)
+		})
+	} else {
+		typingData.ExpireIn = 0
+		manager.stopTypingTimer(key)
+	}
+	manager.relayEphemeral(action, typingData)
+}
+
+func (manager *ClientManager) resetTypingTimer(key string, onExpire func()) {
+	manager.typingLock.Lock()
+	defer manager.typingLock.Unlock()
+	if timer, ok := manager.typingTimers[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(TypingTTL, func() {
+		manager.typingLock.Lock()
+		//已经被新的start替换掉了
+		if manager.typingTimers[key] != timer {
+			manager.typingLock.Unlock()
+			return
+		}
+		delete(manager.typingTimers, key)
+		manager.typingLock.Unlock()
+		onExpire()
+	})
+	manager.typingTimers[key] = timer
+}
+
+func (manager *ClientManager) stopTypingTimer(key string) {
+	manager.typingLock.Lock()
+	defer manager.typingLock.Unlock()
+	if timer, ok := manager.typingTimers[key]; ok {
+		timer.Stop()
+		delete(manager.typingTimers, key)
+	}
+}
+
+// 转发给会话里除了发送者以外的所有在线用户
+func (manager *ClientManager) relayEphemeral(action Action, typingData *TypingContent) {
+	jsonBytes, err := NewMessage(action, typingData)
+	if err != nil {
+		zlog.Error("marshal ephemeral event failed", zap.Error(err))
+		return
+	}
+	var targets []string
+	for _, userId := range manager.conversationMembers(typingData.Type, typingData.SendId, typingData.ReceiverId) {
+		if userId != typingData.SendId {
+			targets = append(targets, userId)
+		}
+	}
+	manager.sendToUsers(targets, jsonBytes)
+}
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"my-chat/internal/model"
+	"my-chat/pkg/util/snowflake"
+	"my-chat/pkg/zlog"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ExpireSweepInterval = 10 * time.Second
+	expireSweepBatch    = 500
+)
+
+// StartExpireSweeper 定时物理删除过期的阅后即焚消息，并通知在线客户端删除本地副本，ctx取消后退出
+func (manager *ClientManager) StartExpireSweeper(ctx context.Context) {
+	ticker := time.NewTicker(ExpireSweepInterval)
+	defer ticker.Stop()
+	zlog.Info("Expire sweeper started...")
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Info("Expire sweeper stopped")
+			return
+		case <-ticker.C:
+			manager.sweepExpiredMessages()
+		}
+	}
+}
+
+func (manager *ClientManager) sweepExpiredMessages() {
+	for {
+		purged, err := manager.chatService.PurgeExpired(expireSweepBatch)
+		if err != nil {
+			zlog.Error("purge expired messages failed", zap.Error(err))
+			return
+		}
+		if len(purged) > 0 {
+			zlog.Info("purged expired messages", zap.Int("count", len(purged)))
+			manager.dropPurgedPending(purged)
+			manager.refreshPurgedPreviews(purged)
+			manager.notifyDeleted(purged)
+		}
+		//没满一批说明清完了，或者别的节点在清
+		if len(purged) < expireSweepBatch {
+			return
+		}
+	}
+}
+
+// 清掉已删除消息的待确认记录，原文不能再留在redis里，也不能在上线时补发
+func (manager *ClientManager) dropPurgedPending(messages []*model.Message) {
+	membersByConv := make(map[string][]string)
+	for _, msg := range messages {
+		convId := model.ConversationId(msg.Type, msg.FromUserId, msg.ToId)
+		members, ok := membersByConv[convId]
+		if !ok {
+			members = manager.conversationMembers(msg.Type, msg.FromUserId, msg.ToId)
+			membersByConv[convId] = members
+		}
+		if err := manager.pendingRepo.DropMessage(msg.Uuid, members); err != nil {
+			zlog.Error("drop pending failed", zap.String("msg_id", msg.Uuid), zap.Error(err))
+		}
+	}
+}
+
+// 删掉的如果是会话最新一条，预览回退到剩下的最新消息，会话空了就清空预览
+func (manager *ClientManager) refreshPurgedPreviews(messages []*model.Message) {
+	newest := make(map[string]*model.Message)
+	var convIds []string
+	for _, msg := range messages {
+		convId := model.ConversationId(msg.Type, msg.FromUserId, msg.ToId)
+		prev, ok := newest[convId]
+		if !ok {
+			convIds = append(convIds, convId)
+		}
+		if !ok || msg.Seq > prev.Seq {
+			newest[convId] = msg
+		}
+	}
+	for _, convId := range convIds {
+		purged := newest[convId]
+		latest, err := manager.chatService.LatestMessage(purged)
+		if err != nil {
+			zlog.Error("load latest message failed", zap.String("conv_id", convId), zap.Error(err))
+			continue
+		}
+		//剩下的消息比删掉的新，说明预览本来就不是删掉的那条
+		if latest != nil && latest.Seq > purged.Seq {
+			continue
+		}
+		preview, lastTime := "", purged.CreatedAt.Unix()
+		if latest != nil {
+			preview, lastTime = model.MessagePreview(latest.MediaType, latest.Content), latest.CreatedAt.Unix()
+			if latest.Status == model.MsgStatusRecalled {
+				preview = model.RecalledPlaceholder
+			}
+		}
+		manager.setConversationPreview(purged, preview, lastTime)
+	}
+}
+
+// 按会话合并后推送删除事件
+func (manager *ClientManager) notifyDeleted(messages []*model.Message) {
+	events := make(map[string]*DeleteContent)
+	var convIds []string
+	for _, msg := range messages {
+		convId := model.ConversationId(msg.Type, msg.FromUserId, msg.ToId)
+		event, ok := events[convId]
+		if !ok {
+			event = &DeleteContent{SendId: msg.FromUserId, ReceiverId: msg.ToId, Type: msg.Type}
+			events[convId] = event
+			convIds = append(convIds, convId)
+		}
+		event.MsgIds = append(event.MsgIds, msg.Uuid)
+	}
+	for _, convId := range convIds {
+		event := events[convId]
+		jsonBytes, err := NewMessage(ActionDelete, event)
+		if err != nil {
+			zlog.Error("marshal delete event failed", zap.Error(err))
+			continue
+		}
+		manager.pushToConversation(event.Type, event.SendId, event.ReceiverId, jsonBytes)
+	}
+}
+
+// SetMessageTTL 修改会话的阅后即焚时长，并在会话里发一条系统提示
+func (manager *ClientManager) SetMessageTTL(userId, targetId string, chatType int, ttl int64) error {
+	if err := manager.chatService.SetMessageTTL(userId, targetId, chatType, ttl); err != nil {
+		return err
+	}
+	chatData := &ChatMessageContent{
+		SendId:     userId,
+		ReceiverId: targetId,
+		Type:       chatType,
+		Content:    ttlNotice(ttl),
+		MediaType:  model.MediaTypeSystem,
+		Uuid:       snowflake.GenStringID(),
+		Timestamp:  time.Now().Unix(),
+	}
+	if err := manager.publishChat(chatData); err != nil {
+		//设置已经生效，提示没发出去不影响结果
+		zlog.Error("publish ttl notice failed", zap.Error(err))
+	}
+	return nil
+}
+
+func ttlNotice(ttl int64) string {
+	if ttl == 0 {
+		return "关闭了阅后即焚"
+	}
+	return "开启了阅后即焚，新消息将在" + formatTTL(ttl) + "后自动删除"
+}
+
+func formatTTL(ttl int64) string {
+	switch {
+	case ttl%86400 == 0:
+		return fmt.Sprintf("%d天", ttl/86400)
+	case ttl%3600 == 0:
+		return fmt.Sprintf("%d小时", ttl/3600)
+	case ttl%60 == 0:
+		return fmt.Sprintf("%d分钟", ttl/60)
+	default:
+		return fmt.Sprintf("%d秒", ttl)
+	}
+}
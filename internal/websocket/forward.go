@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/util/snowflake"
+	"my-chat/pkg/zlog"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 一次最多转发给几个会话
+const MaxForwardTargets = 9
+
+// 合并转发没填标题时的默认标题
+const defaultRecordTitle = "聊天记录"
+
+type ForwardTarget struct {
+	TargetId string `json:"target_id" binding:"required"`
+	Type     int    `json:"type" binding:"required"` //1-私聊 2-群聊
+}
+
+// 每个目标会话的转发结果，失败的带错误码，不影响其他目标
+type ForwardResult struct {
+	TargetId string   `json:"target_id"`
+	Type     int      `json:"type"`
+	MsgIds   []string `json:"msg_ids,omitempty"`
+	Code     int      `json:"code"`
+	Message  string   `json:"message"`
+}
+
+// Forward 转发消息：逐条转发时每条原样重发并标记为转发，合并转发生成一条聊天记录消息
+// 和普通消息一样投递到kafka，由StartConsumer落库和推送
+func (manager *ClientManager) Forward(userId string, msgIds []string, targets []ForwardTarget, merged bool, title string) ([]ForwardResult, error) {
+	if len(targets) == 0 || len(targets) > MaxForwardTargets {
+		return nil, errno.ErrForwardInvalid
+	}
+	originals, err := manager.chatService.LoadForwardable(userId, msgIds, merged)
+	if err != nil {
+		return nil, err
+	}
+	var contents []*ChatMessageContent
+	if merged {
+		if title == "" {
+			title = defaultRecordTitle
+		}
+		contents = []*ChatMessageContent{{
+			Content:   title,
+			MediaType: model.MediaTypeRecord,
+			Record:    model.NewChatRecord(title, originals),
+		}}
+	} else {
+		for _, msg := range originals {
+			contents = append(contents, &ChatMessageContent{
+				Content:   msg.Content,
+				MediaType: msg.MediaType,
+				Media:     msg.MediaInfo(),
+				Record:    msg.ChatRecord(),
+			})
+		}
+	}
+
+	results := make([]ForwardResult, 0, len(targets))
+	for _, target := range targets {
+		result := ForwardResult{TargetId: target.TargetId, Type: target.Type}
+		err := manager.forwardTo(userId, target, contents, &result)
+		if err != nil {
+			zlog.Warn("forward message failed",
+				zap.String("userId", userId),
+				zap.String("target", target.TargetId),
+				zap.Error(err))
+		}
+		result.Code, result.Message = errno.Decode(err)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (manager *ClientManager) forwardTo(userId string, target ForwardTarget, contents []*ChatMessageContent, result *ForwardResult) error {
+	if err := manager.chatService.CheckSendPermission(userId, target.Type, target.TargetId); err != nil {
+		return err
+	}
+	for _, content := range contents {
+		chatData := *content
+		chatData.SendId = userId
+		chatData.ReceiverId = target.TargetId
+		chatData.Type = target.Type
+		chatData.Forwarded = true
+		chatData.Uuid = snowflake.GenStringID()
+		chatData.Timestamp = time.Now().Unix()
+		if err := manager.publishChat(&chatData); err != nil {
+			return err
+		}
+		result.MsgIds = append(result.MsgIds, chatData.Uuid)
+	}
+	return nil
+}
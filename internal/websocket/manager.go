@@ -1,8 +1,9 @@
 package websocket
 
 import (
-	"context"
 	"encoding/json"
+	"my-chat/internal/config"
+	"my-chat/internal/model"
 	"my-chat/internal/mq"
 	"my-chat/internal/repo"
 	"my-chat/internal/service"
@@ -14,18 +15,33 @@ import (
 )
 
 type ClientManager struct {
-	Clients    map[string]*Client
-	Register   chan *Client //链接请求
-	Unregister chan *Client //断开连接请求
-	Broadcast  chan []byte  //消息广播
+	Clients    map[string]map[string]*Client //userId -> deviceId -> 连接
+	Register   chan *Client                  //链接请求
+	Unregister chan *Client                  //断开连接请求
 
 	rwLock sync.RWMutex
 	//注入ChatService, 用于存消息
-	chatService *service.ChatService
-	sessionRepo repo.SessionRepository
-	groupRepo   repo.GroupRepository
+	chatService     *service.ChatService
+	sessionService  *service.SessionService
+	presenceService *service.PresenceService
+	sessionRepo     repo.SessionRepository
+	groupRepo       repo.GroupRepository
+	//待确认投递的消息，ACK超时重传
+	pendingRepo   repo.PendingRepository
+	ackTimeout    time.Duration
+	maxRetransmit int
+	//互斥登录的设备类别
+	exclusiveClasses map[string]bool
+	//多节点部署：本节点ID，用户路由表，节点间投递通道
+	nodeId    string
+	routeRepo repo.RouteRepository
+	nodeBus   mq.Bus
+	//正在输入的自动过期定时器
+	typingTimers map[string]*time.Timer
+	typingLock   sync.Mutex
 
-	mqClient *mq.KafkaClient
+	mqClient mq.Queue
+	offsets  *mq.OffsetTracker //并发消费时按分区跟踪可提交的offset
 }
 
 // 超时常量，为了方便测试超时的时间设置的比较长
@@ -34,15 +50,42 @@ const (
 	HeartbeatTimeout  = 300
 )
 
-func NewClientManager(chatService *service.ChatService, sessionRepo repo.SessionRepository, mqClient *mq.KafkaClient) *ClientManager {
+func NewClientManager(chatService *service.ChatService, sessionService *service.SessionService, presenceService *service.PresenceService,
+	sessionRepo repo.SessionRepository, groupRepo repo.GroupRepository, pendingRepo repo.PendingRepository, routeRepo repo.RouteRepository,
+	mqClient mq.Queue, nodeBus mq.Bus, nodeId string, cfg *config.ChatConfig) *ClientManager {
+	ackTimeout := DefaultAckTimeout
+	maxRetransmit := DefaultMaxRetransmit
+	exclusiveClasses := make(map[string]bool)
+	if cfg != nil {
+		if cfg.AckTimeout > 0 {
+			ackTimeout = time.Duration(cfg.AckTimeout) * time.Second
+		}
+		if cfg.MaxRetransmit > 0 {
+			maxRetransmit = cfg.MaxRetransmit
+		}
+		for _, class := range cfg.ExclusiveDevices {
+			exclusiveClasses[class] = true
+		}
+	}
 	return &ClientManager{
-		Register:    make(chan *Client),
-		Unregister:  make(chan *Client),
-		Broadcast:   make(chan []byte),
-		Clients:     make(map[string]*Client),
-		chatService: chatService,
-		sessionRepo: sessionRepo,
-		mqClient:    mqClient,
+		Register:         make(chan *Client),
+		Unregister:       make(chan *Client),
+		Clients:          make(map[string]map[string]*Client),
+		chatService:      chatService,
+		sessionService:   sessionService,
+		presenceService:  presenceService,
+		sessionRepo:      sessionRepo,
+		groupRepo:        groupRepo,
+		pendingRepo:      pendingRepo,
+		ackTimeout:       ackTimeout,
+		maxRetransmit:    maxRetransmit,
+		exclusiveClasses: exclusiveClasses,
+		offsets:          mq.NewOffsetTracker(),
+		nodeId:           nodeId,
+		routeRepo:        routeRepo,
+		nodeBus:          nodeBus,
+		typingTimers:     make(map[string]*time.Timer),
+		mqClient:         mqClient,
 	}
 }
 func (manager *ClientManager) StartHeartbeat() {
@@ -54,16 +97,37 @@ func (manager *ClientManager) StartHeartbeat() {
 		//加锁，要遍历Clients map
 		manager.rwLock.Lock()
 		now := time.Now().Unix()
-		for userId, client := range manager.Clients {
-			if now-client.HeartbeatTime > HeartbeatTimeout {
-				zlog.Warn("心跳超时，下线",
-					zap.String("userId", userId),
-					zap.Int64("last_beat", client.HeartbeatTime))
-				client.Conn.Close()
+		var timeoutClients []*Client
+		aliveDevices := make(map[string][]string)
+		for userId, devices := range manager.Clients {
+			for deviceId, client := range devices {
+				if now-client.HeartbeatTime > HeartbeatTimeout {
+					zlog.Warn("心跳超时，下线",
+						zap.String("userId", userId),
+						zap.String("deviceId", deviceId),
+						zap.Int64("last_beat", client.HeartbeatTime))
+					client.Conn.Close()
+					delete(devices, deviceId)
+					timeoutClients = append(timeoutClients, client)
+					continue
+				}
+				aliveDevices[userId] = append(aliveDevices[userId], deviceId)
+			}
+			if len(devices) == 0 {
 				delete(manager.Clients, userId)
 			}
 		}
 		manager.rwLock.Unlock()
+		//在线状态和路由续期，放在锁外面
+		if err := manager.presenceService.Refresh(aliveDevices); err != nil {
+			zlog.Error("refresh presence failed", zap.Error(err))
+		}
+		if err := manager.routeRepo.Refresh(manager.nodeId, aliveDevices); err != nil {
+			zlog.Error("refresh route failed", zap.Error(err))
+		}
+		for _, client := range timeoutClients {
+			manager.unbindClient(client)
+		}
 	}
 }
 func (manager *ClientManager) Start() {
@@ -72,41 +136,94 @@ func (manager *ClientManager) Start() {
 	go manager.StartHeartbeat()
 	//启动消费者
 	go manager.StartConsumer()
+	//启动ACK超时重传
+	go manager.StartRetransmit()
+	//启动节点间投递通道
+	go manager.StartNodeSubscriber()
 	for {
 		select {
 		case client := <-manager.Register:
-			manager.rwLock.Lock()
-			//检查有没有旧连接
-			var oldClientToClose *Client
-			if oldClient, ok := manager.Clients[client.UserId]; ok {
-				oldClientToClose = oldClient //记下来，等会儿关闭，不占用锁
-			}
-			manager.Clients[client.UserId] = client
-			manager.rwLock.Unlock()
-			zlog.Info("New connection",
-				zap.String("uuid", client.UserId))
-			if oldClientToClose != nil {
-				close(oldClientToClose.Send)
-				zlog.Info("Close old connection", zap.String("uuid", client.UserId))
-			}
+			manager.registerClient(client)
+			//补发离线期间没确认的消息
+			go manager.redeliverPending(client.UserId)
 
 		case client := <-manager.Unregister:
-			manager.rwLock.Lock()
-			if _, ok := manager.Clients[client.UserId]; ok {
-				delete(manager.Clients, client.UserId)
-				close(client.Send)
+			if manager.removeClient(client) {
+				manager.unbindClient(client)
 			}
-			manager.rwLock.Unlock()
-			zlog.Info("Disconnect", zap.String("uuid", client.UserId))
+			zlog.Info("Disconnect",
+				zap.String("uuid", client.UserId),
+				zap.String("deviceId", client.DeviceId))
+		}
+	}
+}
+
+// 登记新连接：同一设备重连，或者互斥类别的其他设备，旧连接会被踢下线
+func (manager *ClientManager) registerClient(client *Client) {
+	class := DeviceClass(client.DeviceType)
+	manager.rwLock.Lock()
+	devices, ok := manager.Clients[client.UserId]
+	if !ok {
+		devices = make(map[string]*Client)
+		manager.Clients[client.UserId] = devices
+	}
+	//记下来，等会儿关闭，不占用锁
+	var oldClientsToClose []*Client
+	for deviceId, oldClient := range devices {
+		if deviceId == client.DeviceId ||
+			(manager.exclusiveClasses[class] && DeviceClass(oldClient.DeviceType) == class) {
+			oldClientsToClose = append(oldClientsToClose, oldClient)
+			delete(devices, deviceId)
+		}
+	}
+	devices[client.DeviceId] = client
+	manager.rwLock.Unlock()
+	zlog.Info("New connection",
+		zap.String("uuid", client.UserId),
+		zap.String("deviceId", client.DeviceId),
+		zap.String("deviceType", client.DeviceType))
+	manager.bindClient(client)
 
-		case message := <-manager.Broadcast:
-			manager.dispatch(message)
+	for _, oldClient := range oldClientsToClose {
+		//已经不在map里了，不会再有人往Send里写，先发通知再关闭
+		kickMsg, _ := NewMessage(ActionKickOff, &KickOffContent{
+			Reason:     "账号已在其他设备登录",
+			DeviceType: client.DeviceType,
+		})
+		select {
+		case oldClient.Send <- kickMsg:
+		default:
+		}
+		close(oldClient.Send)
+		//被互斥设备顶掉的，在线设备里也要去掉
+		if oldClient.DeviceId != client.DeviceId {
+			manager.unbindClient(oldClient)
 		}
+		zlog.Info("Close old connection",
+			zap.String("uuid", oldClient.UserId),
+			zap.String("deviceId", oldClient.DeviceId))
 	}
 }
 
-// 处理消息分发
-func (manager *ClientManager) dispatch(message []byte) {
+// 移除连接，只移除自己：被顶掉的旧连接断开时不能把新连接删了
+func (manager *ClientManager) removeClient(client *Client) bool {
+	manager.rwLock.Lock()
+	defer manager.rwLock.Unlock()
+	devices, ok := manager.Clients[client.UserId]
+	if !ok || devices[client.DeviceId] != client {
+		return false
+	}
+	delete(devices, client.DeviceId)
+	if len(devices) == 0 {
+		delete(manager.Clients, client.UserId)
+	}
+	close(client.Send)
+	return true
+}
+
+// 处理消息分发，由各连接的读协程并发调用
+func (manager *ClientManager) dispatch(clientMsg *ClientMessage) {
+	message := clientMsg.Data
 	/**var rawMsg Message
 	if err := json.Unmarshal(message, &rawMsg); err != nil {
 		zlog.Error("Failed to unmarshal message", zap.String("message", string(message)), zap.Error(err))
@@ -130,11 +247,68 @@ func (manager *ClientManager) dispatch(message []byte) {
 	}
 	switch baseMsg.Action {
 	case ActionChatMessage:
-		ctx := context.Background()
-		err := manager.mqClient.Publish(ctx, nil, message)
-		if err != nil {
-			zlog.Error("kafka publish error", zap.Error(err))
+		var chatData ChatMessageContent
+		if err := json.Unmarshal(baseMsg.Content, &chatData); err != nil {
+			zlog.Error("Unmarshal chat data failed", zap.Error(err))
+			return
+		}
+		manager.handleChatMessage(clientMsg.Client, &chatData)
+
+	case ActionReCall:
+		var recallData RecallContent
+		if err := json.Unmarshal(baseMsg.Content, &recallData); err != nil {
+			zlog.Error("Unmarshal recall data failed", zap.Error(err))
+			return
 		}
+		manager.handleRecall(clientMsg.Client, &recallData)
+
+	case ActionEdit:
+		var editData EditContent
+		if err := json.Unmarshal(baseMsg.Content, &editData); err != nil {
+			zlog.Error("Unmarshal edit data failed", zap.Error(err))
+			return
+		}
+		manager.handleEdit(clientMsg.Client, &editData)
+
+	case ActionReact:
+		var reactData ReactionContent
+		if err := json.Unmarshal(baseMsg.Content, &reactData); err != nil {
+			zlog.Error("Unmarshal react data failed", zap.Error(err))
+			return
+		}
+		manager.handleReact(clientMsg.Client, &reactData)
+
+	case ActionSync:
+		var syncData SyncContent
+		if err := json.Unmarshal(baseMsg.Content, &syncData); err != nil {
+			zlog.Error("Unmarshal sync data failed", zap.Error(err))
+			return
+		}
+		manager.handleSync(clientMsg.Client, &syncData)
+
+	case ActionSessionRead:
+		var readData SessionReadContent
+		if err := json.Unmarshal(baseMsg.Content, &readData); err != nil {
+			zlog.Error("Unmarshal session read data failed", zap.Error(err))
+			return
+		}
+		manager.handleSessionRead(clientMsg.Client, &readData)
+
+	case ActionRead:
+		var receiptData ReadReceiptContent
+		if err := json.Unmarshal(baseMsg.Content, &receiptData); err != nil {
+			zlog.Error("Unmarshal read receipt failed", zap.Error(err))
+			return
+		}
+		manager.handleReadReceipt(clientMsg.Client, &receiptData)
+
+	case ActionTypingStart, ActionTypingStop:
+		var typingData TypingContent
+		if err := json.Unmarshal(baseMsg.Content, &typingData); err != nil {
+			zlog.Error("Unmarshal typing data failed", zap.Error(err))
+			return
+		}
+		manager.handleTyping(clientMsg.Client, baseMsg.Action, &typingData)
 
 	case ActionHeartbeat:
 	case ActionAck:
@@ -144,21 +318,66 @@ func (manager *ClientManager) dispatch(message []byte) {
 		}
 		zlog.Info("收到ACK",
 			zap.String("msg_id", ackData.MsgId),
-			zap.String("user_id", ackData.UserId))
+			zap.String("user_id", clientMsg.Client.UserId))
+		//以连接上的用户为准，不信任客户端带上来的user_id
+		if err := manager.pendingRepo.RemovePending(clientMsg.Client.UserId, ackData.MsgId); err != nil {
+			zlog.Error("remove pending failed", zap.String("msg_id", ackData.MsgId), zap.Error(err))
+		}
 	}
 }
-func (manager *ClientManager) sendToUser(targetId string, msg []byte) {
+
+// 会话的所有参与者：单聊为双方，群聊为所有群成员
+func (manager *ClientManager) conversationMembers(chatType int, sendId, receiverId string) []string {
+	if chatType == model.MsgTypeSingle {
+		return []string{receiverId, sendId}
+	} else if chatType == model.MsgTypeGroup {
+		memberIds, _ := manager.chatService.GetGroupMemberIDs(receiverId)
+		return memberIds
+	}
+	return nil
+}
+
+// 推送给会话的所有参与者，每个人的所有在线设备都会收到，发送者的其他设备借此同步已发消息
+func (manager *ClientManager) pushToConversation(chatType int, sendId, receiverId string, msg []byte) {
+	manager.sendToUsers(manager.conversationMembers(chatType, sendId, receiverId), msg)
+}
+
+// 只发给某一个连接，用于请求-响应类的消息
+func (manager *ClientManager) sendToClient(client *Client, msg []byte) {
 	manager.rwLock.RLock()
 	defer manager.rwLock.RUnlock()
-	if client, ok := manager.Clients[targetId]; ok {
+	if manager.Clients[client.UserId][client.DeviceId] != client {
+		return
+	}
+	select {
+	case client.Send <- msg:
+	default:
+		zlog.Warn("client send buffer full, drop message", zap.String("userId", client.UserId))
+	}
+}
+
+// 推送给用户的所有在线设备，包括连在其他节点上的
+func (manager *ClientManager) sendToUser(targetId string, msg []byte) {
+	manager.sendToUsers([]string{targetId}, msg)
+}
+
+// 只推送给连在本节点上的设备
+func (manager *ClientManager) sendLocal(targetId string, msg []byte) {
+	var slowClients []*Client
+	manager.rwLock.RLock()
+	devices := manager.Clients[targetId]
+	for _, client := range devices {
 		select {
 		case client.Send <- msg:
 		default:
-			// 缓冲区满了，直接关闭连接，防止阻塞 Manager
-			close(client.Send)
-			delete(manager.Clients, client.UserId)
+			slowClients = append(slowClients, client)
+		}
+	}
+	manager.rwLock.RUnlock()
+	// 缓冲区满了，直接关闭连接，防止阻塞 Manager
+	for _, client := range slowClients {
+		if manager.removeClient(client) {
+			manager.unbindClient(client)
 		}
-	} else {
-		zlog.Debug("User offline, cannot send message", zap.String("target", targetId))
 	}
 }
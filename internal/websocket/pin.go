@@ -0,0 +1,25 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// NotifyPinChanged 置顶变化后推给会话所有参与者，置顶接口调用
+func (manager *ClientManager) NotifyPinChanged(msg *model.Message, operatorId, op string) {
+	jsonBytes, err := NewMessage(ActionPin, &PinContent{
+		MsgId:      msg.Uuid,
+		Op:         op,
+		OperatorId: operatorId,
+		SendId:     msg.FromUserId,
+		ReceiverId: msg.ToId,
+		Type:       msg.Type,
+	})
+	if err != nil {
+		zlog.Error("marshal pin event failed", zap.Error(err))
+		return
+	}
+	manager.pushToConversation(msg.Type, msg.FromUserId, msg.ToId, jsonBytes)
+}
@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/zlog"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 设备上线，用户从离线变在线时通知好友
+func (manager *ClientManager) presenceOnline(client *Client) {
+	becameOnline, err := manager.presenceService.Online(client.UserId, client.DeviceId)
+	if err != nil {
+		zlog.Error("set presence online failed", zap.String("userId", client.UserId), zap.Error(err))
+		return
+	}
+	if becameOnline {
+		go manager.notifyPresence(&model.Presence{
+			UserId:   client.UserId,
+			Online:   true,
+			LastSeen: time.Now().Unix(),
+		})
+	}
+}
+
+// 设备下线，所有设备都下线时通知好友
+func (manager *ClientManager) presenceOffline(client *Client) {
+	wentOffline, err := manager.presenceService.Offline(client.UserId, client.DeviceId)
+	if err != nil {
+		zlog.Error("set presence offline failed", zap.String("userId", client.UserId), zap.Error(err))
+		return
+	}
+	if wentOffline {
+		go manager.notifyPresence(&model.Presence{
+			UserId:   client.UserId,
+			Online:   false,
+			LastSeen: time.Now().Unix(),
+		})
+	}
+}
+
+func (manager *ClientManager) notifyPresence(presence *model.Presence) {
+	friendIds, err := manager.presenceService.GetFriendIds(presence.UserId)
+	if err != nil {
+		zlog.Error("get friend ids failed", zap.String("userId", presence.UserId), zap.Error(err))
+		return
+	}
+	jsonBytes, err := NewMessage(ActionPresence, presence)
+	if err != nil {
+		zlog.Error("marshal presence failed", zap.Error(err))
+		return
+	}
+	manager.sendToUsers(friendIds, jsonBytes)
+}
@@ -1,6 +1,10 @@
 package websocket
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"my-chat/internal/model"
+	"my-chat/internal/service"
+)
 
 // 消息类型
 type Action string
@@ -10,7 +14,24 @@ const (
 	ActionLogin       Action = "login"        //登录
 	ActionChatMessage Action = "chat_message" //聊天消息
 	ActionReCall      Action = "recall"       //撤回
+	ActionEdit        Action = "edit"         //编辑消息，上行
+	ActionEdited      Action = "edited"       //消息被编辑，下行推给会话参与者
+	ActionReact       Action = "react"        //添加/取消表情回应，上行
+	ActionReaction    Action = "reaction"     //表情回应变化，下行推给会话参与者
+	ActionMention     Action = "mention"      //有人@我，下行单独推给被@的人，免打扰的会话也要提醒
+	ActionPin         Action = "pin"          //置顶消息变化，下行推给会话参与者
+	ActionDelete      Action = "delete"       //消息被删除（阅后即焚到期），下行推给会话参与者
 	ActionAck         Action = "ack"
+	ActionSendAck     Action = "send_ack"     //服务端收到消息后回给发送者，带服务端消息ID
+	ActionSync        Action = "sync"         //增量同步
+	ActionKickOff     Action = "kick_off"     //被同设备或互斥设备顶下线
+	ActionSessionRead Action = "session_read" //会话已读，多端同步未读数
+	ActionRead        Action = "read"         //消息已读回执，和投递ACK区分开
+	ActionPresence    Action = "presence"     //好友上下线
+	ActionError       Action = "error"        //上行消息处理失败，回给发送者
+	//以下为临时事件，在线直接转发，不经过kafka也不落库
+	ActionTypingStart Action = "typing_start" //正在输入
+	ActionTypingStop  Action = "typing_stop"  //停止输入
 )
 
 type Message struct {
@@ -21,13 +42,172 @@ type Message struct {
 	TraceId string `json:"trace_id,omitempty"`
 }
 type ChatMessageContent struct {
-	SendId     string `json:"send_id"`     //发送者
-	ReceiverId string `json:"receiver_id"` //接收者
-	Type       int    `json:"type"`        //1:文本， 2：图片
-	Content    string `json:"content"`     //文本内容 or 图片内容
-	Uuid       string `json:"uuid"`        //ACK
+	SendId      string            `json:"send_id"`                 //发送者
+	ReceiverId  string            `json:"receiver_id"`             //接收者
+	Type        int               `json:"type"`                    //1:文本， 2：图片
+	Content     string            `json:"content"`                 //文本内容，富媒体消息可作为说明文字
+	Uuid        string            `json:"uuid"`                    //服务端消息ID，由网关分配
+	Seq         int64             `json:"seq"`                     //会话内序号，由服务端分配
+	MediaType   int               `json:"media_type,omitempty"`    //1:文本 2:图片 3:语音 4:视频 5:文件 6:位置，不填为文本
+	Media       *model.MediaInfo  `json:"media,omitempty"`         //富媒体信息，url/size/mime由服务端按附件记录填充
+	ClientMsgId string            `json:"client_msg_id,omitempty"` //客户端生成的消息ID，重发时不变
+	Timestamp   int64             `json:"timestamp,omitempty"`     //服务端接收时间
+	ReplyTo     string            `json:"reply_to,omitempty"`      //引用回复的消息ID
+	RootId      string            `json:"root_id,omitempty"`       //话题根消息ID，仅群聊；回复话题内消息时服务端会自动填充
+	Quote       *model.QuoteInfo  `json:"quote,omitempty"`         //被引用消息的预览，由服务端填充
+	MentionIds  []string          `json:"mention_ids,omitempty"`   //被@的用户，仅群聊
+	MentionAll  bool              `json:"mention_all,omitempty"`   //@所有人，仅群主和管理员
+	Forwarded   bool              `json:"forwarded,omitempty"`     //转发的消息，由服务端填充
+	Record      *model.ChatRecord `json:"record,omitempty"`        //合并转发的聊天记录，推送时只带摘要
+	ExpireAt    int64             `json:"expire_at,omitempty"`     //阅后即焚的过期时间，由服务端按会话设置填充
+}
+
+// 发送确认：告诉发送者服务端已收到，重复发送时返回第一次分配的ID和时间
+type SendAckContent struct {
+	ClientMsgId string `json:"client_msg_id"`
+	MsgId       string `json:"msg_id"`
+	Timestamp   int64  `json:"timestamp"`
+	Duplicate   bool   `json:"duplicate,omitempty"`
 }
 type AckMessage struct {
 	MsgId  string `json:"msg_id"`
 	UserId string `json:"user_id"`
 }
+
+// 撤回：客户端只需要带msg_id，其余字段由服务端填充后推送
+type RecallContent struct {
+	MsgId      string `json:"msg_id"`
+	OperatorId string `json:"operator_id,omitempty"` //撤回操作人
+	SendId     string `json:"send_id,omitempty"`     //原消息发送者
+	ReceiverId string `json:"receiver_id,omitempty"` //原消息接收者，单聊为用户，群聊为群
+	Type       int    `json:"type,omitempty"`        //1:单聊 2:群聊
+}
+
+// 编辑：客户端上行带msg_id和content，其余字段由服务端填充后以edited事件推送
+type EditContent struct {
+	MsgId      string `json:"msg_id"`
+	Content    string `json:"content"`
+	EditorId   string `json:"editor_id,omitempty"`
+	SendId     string `json:"send_id,omitempty"`
+	ReceiverId string `json:"receiver_id,omitempty"`
+	Type       int    `json:"type,omitempty"` //1:单聊 2:群聊
+	EditedAt   int64  `json:"edited_at,omitempty"`
+}
+
+// 表情回应：客户端上行带msg_id、emoji、op，推送时服务端带上这条消息最新的聚合结果
+type ReactionContent struct {
+	MsgId      string                  `json:"msg_id"`
+	Emoji      string                  `json:"emoji"`
+	Op         string                  `json:"op"` //add:添加 remove:取消
+	UserId     string                  `json:"user_id,omitempty"`
+	SendId     string                  `json:"send_id,omitempty"`
+	ReceiverId string                  `json:"receiver_id,omitempty"`
+	Type       int                     `json:"type,omitempty"` //1:单聊 2:群聊
+	Reactions  []model.ReactionSummary `json:"reactions,omitempty"`
+}
+
+// 表情回应操作
+const (
+	ReactionOpAdd    = "add"
+	ReactionOpRemove = "remove"
+)
+
+// @提醒
+type MentionContent struct {
+	MsgId      string `json:"msg_id"`
+	GroupId    string `json:"group_id"`
+	FromUserId string `json:"from_user_id"`
+	Seq        int64  `json:"seq"`
+	All        bool   `json:"all"`
+	Content    string `json:"content"` //消息预览
+}
+
+// 置顶变化的系统事件
+type PinContent struct {
+	MsgId      string `json:"msg_id"`
+	Op         string `json:"op"` //pin:置顶 unpin:取消置顶
+	OperatorId string `json:"operator_id"`
+	SendId     string `json:"send_id"`
+	ReceiverId string `json:"receiver_id"`
+	Type       int    `json:"type"` //1:单聊 2:群聊
+}
+
+// 置顶操作
+const (
+	PinOpPin   = "pin"
+	PinOpUnpin = "unpin"
+)
+
+// 删除消息事件，同一个会话的合并成一条
+type DeleteContent struct {
+	MsgIds     []string `json:"msg_ids"`
+	SendId     string   `json:"send_id"`
+	ReceiverId string   `json:"receiver_id"`
+	Type       int      `json:"type"` //1:单聊 2:群聊
+}
+
+// 增量同步请求，响应的content为[]service.SyncResult
+type SyncContent struct {
+	Conversations []service.SyncCursor `json:"conversations"`
+	Limit         int                  `json:"limit"`
+}
+
+// 已读回执：客户端展示消息后上报msg_id，其余字段由服务端填充后推给发送者
+type ReadReceiptContent struct {
+	MsgId      string `json:"msg_id"`
+	ReaderId   string `json:"reader_id,omitempty"`
+	SendId     string `json:"send_id,omitempty"`
+	ReceiverId string `json:"receiver_id,omitempty"`
+	Type       int    `json:"type,omitempty"`
+	Seq        int64  `json:"seq,omitempty"`
+}
+
+// 正在输入：客户端带receiver_id和type，send_id和expire_in由服务端填充
+type TypingContent struct {
+	SendId     string `json:"send_id,omitempty"`
+	ReceiverId string `json:"receiver_id"`
+	Type       int    `json:"type"`                //1:单聊 2:群聊
+	ExpireIn   int    `json:"expire_in,omitempty"` //秒，超时没收到stop客户端自己清掉
+}
+
+// 会话已读：客户端上行带target_id和type，服务端处理后推给该用户的所有设备
+type SessionReadContent struct {
+	TargetId string `json:"target_id"`
+	Type     int    `json:"type"`     //1:单聊 2:群聊
+	ReadSeq  int64  `json:"read_seq"` //群聊已读到的seq
+}
+
+// 错误帧：code/message同HTTP接口，带上出错的action和客户端消息ID方便客户端对应
+type ErrorContent struct {
+	Action      Action `json:"action"`
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	ClientMsgId string `json:"client_msg_id,omitempty"`
+}
+
+// 被踢下线的通知
+type KickOffContent struct {
+	Reason     string `json:"reason"`
+	DeviceType string `json:"device_type"` //新登录的设备类型
+}
+
+// 节点间转发的消息，收到的节点只推给本地连接
+type NodeMessage struct {
+	UserIds []string        `json:"user_ids"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// 客户端上行的原始消息，带上所属连接，服务端据此鉴权
+type ClientMessage struct {
+	Client *Client
+	Data   []byte
+}
+
+// 组装下行消息
+func NewMessage(action Action, content interface{}) ([]byte, error) {
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&Message{Action: action, Content: contentBytes})
+}
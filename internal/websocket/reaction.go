@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/errno"
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// 处理表情回应：改库 -> 推送reaction事件，不动会话预览和未读数
+func (manager *ClientManager) handleReact(client *Client, reactData *ReactionContent) {
+	if reactData.Op != ReactionOpAdd && reactData.Op != ReactionOpRemove {
+		manager.sendError(client, ActionReact, "", errno.ErrReactionInvalid)
+		return
+	}
+	msg, reactions, changed, err := manager.chatService.ReactMessage(client.UserId, reactData.MsgId, reactData.Emoji, reactData.Op == ReactionOpAdd)
+	if err != nil {
+		zlog.Warn("react message failed",
+			zap.String("msg_id", reactData.MsgId),
+			zap.String("operator", client.UserId),
+			zap.Error(err))
+		manager.sendError(client, ActionReact, "", err)
+		return
+	}
+	if changed {
+		manager.NotifyReaction(msg, client.UserId, reactData.Emoji, reactData.Op, reactions)
+	}
+}
+
+// NotifyReaction 回应变化后推给会话所有参与者，HTTP接口也走这里
+func (manager *ClientManager) NotifyReaction(msg *model.Message, userId, emoji, op string, reactions []model.ReactionSummary) {
+	event := ReactionContent{
+		MsgId:      msg.Uuid,
+		Emoji:      emoji,
+		Op:         op,
+		UserId:     userId,
+		SendId:     msg.FromUserId,
+		ReceiverId: msg.ToId,
+		Type:       msg.Type,
+		Reactions:  reactions,
+	}
+	jsonBytes, err := NewMessage(ActionReaction, &event)
+	if err != nil {
+		zlog.Error("marshal reaction event failed", zap.Error(err))
+		return
+	}
+	manager.pushToConversation(msg.Type, msg.FromUserId, msg.ToId, jsonBytes)
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// 处理撤回请求：改库 -> 清掉待确认的原消息 -> 更新会话预览 -> 推送撤回事件
+func (manager *ClientManager) handleRecall(client *Client, recallData *RecallContent) {
+	msg, err := manager.chatService.RecallMessage(client.UserId, recallData.MsgId)
+	if err != nil {
+		zlog.Warn("recall message failed",
+			zap.String("msg_id", recallData.MsgId),
+			zap.String("operator", client.UserId),
+			zap.Error(err))
+		manager.sendError(client, ActionReCall, "", err)
+		return
+	}
+	//离线的接收者上线后不能再补发到原文
+	members := manager.conversationMembers(msg.Type, msg.FromUserId, msg.ToId)
+	if err := manager.pendingRepo.DropMessage(msg.Uuid, members); err != nil {
+		zlog.Error("drop pending failed", zap.String("msg_id", msg.Uuid), zap.Error(err))
+	}
+	manager.updateLatestPreview(msg, model.RecalledPlaceholder)
+
+	event := RecallContent{
+		MsgId:      msg.Uuid,
+		OperatorId: client.UserId,
+		SendId:     msg.FromUserId,
+		ReceiverId: msg.ToId,
+		Type:       msg.Type,
+	}
+	jsonBytes, err := NewMessage(ActionReCall, &event)
+	if err != nil {
+		zlog.Error("marshal recall event failed", zap.Error(err))
+		return
+	}
+	manager.pushToConversation(msg.Type, msg.FromUserId, msg.ToId, jsonBytes)
+}
+
+// 撤回或编辑的是最新一条消息时，会话列表的预览也要跟着变
+func (manager *ClientManager) updateLatestPreview(msg *model.Message, preview string) {
+	isLatest, err := manager.chatService.IsLatestMessage(msg)
+	if err != nil {
+		zlog.Error("check latest message failed", zap.String("uuid", msg.Uuid), zap.Error(err))
+		return
+	}
+	if !isLatest {
+		return
+	}
+	manager.setConversationPreview(msg, preview, msg.CreatedAt.Unix())
+}
+
+// 改写msg所在会话的预览，并失效相关用户的会话列表缓存
+func (manager *ClientManager) setConversationPreview(msg *model.Message, preview string, lastTime int64) {
+	if msg.Type == model.MsgTypeSingle {
+		_ = manager.sessionRepo.UpdateLastMsg(msg.FromUserId, msg.ToId, preview)
+		_ = manager.sessionRepo.DeleteSessionCache(msg.FromUserId)
+		_ = manager.sessionRepo.UpdateLastMsg(msg.ToId, msg.FromUserId, preview)
+		_ = manager.sessionRepo.DeleteSessionCache(msg.ToId)
+	} else if msg.Type == model.MsgTypeGroup {
+		if preview != "" {
+			preview = "群消息:" + preview
+		}
+		err := manager.groupRepo.UpdateGroupLastMsg(msg.ToId, preview, lastTime)
+		if err != nil {
+			zlog.Error("update group last msg failed", zap.Error(err))
+			return
+		}
+		//群会话预览缓存在每个成员的会话列表里，逐个失效
+		memberIds, _ := manager.chatService.GetGroupMemberIDs(msg.ToId)
+		for _, memberId := range memberIds {
+			_ = manager.sessionRepo.DeleteSessionCache(memberId)
+		}
+	}
+}
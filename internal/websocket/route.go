@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// 连接建立：登记在线状态和路由
+func (manager *ClientManager) bindClient(client *Client) {
+	manager.presenceOnline(client)
+	if err := manager.routeRepo.Bind(client.UserId, client.DeviceId, manager.nodeId); err != nil {
+		zlog.Error("bind route failed", zap.String("userId", client.UserId), zap.Error(err))
+	}
+}
+
+// 连接断开：清掉在线状态和路由
+func (manager *ClientManager) unbindClient(client *Client) {
+	manager.presenceOffline(client)
+	if err := manager.routeRepo.Unbind(client.UserId, client.DeviceId, manager.nodeId); err != nil {
+		zlog.Error("unbind route failed", zap.String("userId", client.UserId), zap.Error(err))
+	}
+}
+
+// 推送给一批用户：本节点的连接直接写，连在其他节点上的按节点打包转发
+func (manager *ClientManager) sendToUsers(userIds []string, msg []byte) {
+	if len(userIds) == 0 {
+		return
+	}
+	for _, userId := range userIds {
+		manager.sendLocal(userId, msg)
+	}
+	nodeUsers, err := manager.routeRepo.GetUserNodes(userIds)
+	if err != nil {
+		zlog.Error("get user nodes failed", zap.Error(err))
+		return
+	}
+	for nodeId, targets := range nodeUsers {
+		if nodeId == manager.nodeId {
+			continue
+		}
+		nodeMsg, err := json.Marshal(&NodeMessage{UserIds: targets, Payload: msg})
+		if err != nil {
+			zlog.Error("marshal node message failed", zap.Error(err))
+			return
+		}
+		if err := manager.nodeBus.Publish(context.Background(), nodeId, nodeMsg); err != nil {
+			zlog.Error("publish to node failed", zap.String("node", nodeId), zap.Error(err))
+		}
+	}
+}
+
+// StartNodeSubscriber 接收其他节点转发过来的消息，只推本地连接，不再二次转发
+func (manager *ClientManager) StartNodeSubscriber() {
+	zlog.Info("Node subscriber started...", zap.String("node", manager.nodeId))
+	for data := range manager.nodeBus.Subscribe(context.Background(), manager.nodeId) {
+		var nodeMsg NodeMessage
+		if err := json.Unmarshal(data, &nodeMsg); err != nil {
+			zlog.Error("unmarshal node message failed", zap.Error(err))
+			continue
+		}
+		for _, userId := range nodeMsg.UserIds {
+			manager.sendLocal(userId, nodeMsg.Payload)
+		}
+	}
+}
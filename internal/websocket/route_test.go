@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"context"
+	"my-chat/pkg/zlog"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 内存版的节点通道，多个manager共用一个，代替redis pub/sub
+type fakeBus struct {
+	mu       sync.Mutex
+	channels map[string]chan []byte
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{channels: make(map[string]chan []byte)}
+}
+
+func (b *fakeBus) channel(nodeId string) chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.channels[nodeId]
+	if !ok {
+		ch = make(chan []byte, 16)
+		b.channels[nodeId] = ch
+	}
+	return ch
+}
+
+func (b *fakeBus) Publish(ctx context.Context, nodeId string, payload []byte) error {
+	b.channel(nodeId) <- payload
+	return nil
+}
+
+func (b *fakeBus) Subscribe(ctx context.Context, nodeId string) <-chan []byte {
+	return b.channel(nodeId)
+}
+
+func (b *fakeBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.channels {
+		close(ch)
+	}
+}
+
+// 内存版的路由表：userId -> nodeId
+type fakeRoutes struct {
+	mu    sync.Mutex
+	nodes map[string]string
+}
+
+func (r *fakeRoutes) Bind(userId, deviceId, nodeId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[userId] = nodeId
+	return nil
+}
+
+func (r *fakeRoutes) Unbind(userId, deviceId, nodeId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, userId)
+	return nil
+}
+
+func (r *fakeRoutes) Refresh(nodeId string, devices map[string][]string) error {
+	return nil
+}
+
+func (r *fakeRoutes) GetUserNodes(userIds []string) (map[string][]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make(map[string][]string)
+	for _, userId := range userIds {
+		if nodeId, ok := r.nodes[userId]; ok {
+			result[nodeId] = append(result[nodeId], userId)
+		}
+	}
+	return result, nil
+}
+
+// 直接挂一个本地连接，不走registerClient，避免依赖在线状态服务
+func attachClient(manager *ClientManager, routes *fakeRoutes, userId, deviceId string) *Client {
+	client := &Client{Manager: manager, UserId: userId, DeviceId: deviceId, Send: make(chan []byte, 8)}
+	manager.Clients[userId] = map[string]*Client{deviceId: client}
+	_ = routes.Bind(userId, deviceId, manager.nodeId)
+	return client
+}
+
+func expectMessage(t *testing.T, client *Client, want string) {
+	t.Helper()
+	select {
+	case got := <-client.Send:
+		if string(got) != want {
+			t.Fatalf("%s got %s, want %s", client.UserId, got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not receive message", client.UserId)
+	}
+}
+
+func expectNoMessage(t *testing.T, client *Client) {
+	t.Helper()
+	select {
+	case got := <-client.Send:
+		t.Fatalf("%s got unexpected message %s", client.UserId, got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendToUsersAcrossNodes(t *testing.T) {
+	zlog.L = zap.NewNop()
+	bus := newFakeBus()
+	defer bus.close()
+	routes := &fakeRoutes{nodes: make(map[string]string)}
+	nodeA := NewClientManager(nil, nil, nil, nil, nil, nil, routes, nil, bus, "node-a", nil)
+	nodeB := NewClientManager(nil, nil, nil, nil, nil, nil, routes, nil, bus, "node-b", nil)
+	go nodeA.StartNodeSubscriber()
+	go nodeB.StartNodeSubscriber()
+
+	alice := attachClient(nodeA, routes, "alice", "web")
+	bob := attachClient(nodeB, routes, "bob", "ios")
+	carol := attachClient(nodeB, routes, "carol", "android")
+
+	msg, err := NewMessage(ActionChatMessage, &ChatMessageContent{SendId: "alice", ReceiverId: "bob", Type: 1, Content: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA.sendToUsers([]string{"alice", "bob"}, msg)
+
+	//本节点直接推，其他节点经过通道转发，只推给目标用户
+	expectMessage(t, alice, string(msg))
+	expectMessage(t, bob, string(msg))
+	expectNoMessage(t, carol)
+	//本节点的连接不会再经过通道收到第二份
+	expectNoMessage(t, alice)
+}
+
+func TestSendToUsersOfflineUser(t *testing.T) {
+	zlog.L = zap.NewNop()
+	bus := newFakeBus()
+	defer bus.close()
+	routes := &fakeRoutes{nodes: make(map[string]string)}
+	nodeA := NewClientManager(nil, nil, nil, nil, nil, nil, routes, nil, bus, "node-a", nil)
+	nodeB := NewClientManager(nil, nil, nil, nil, nil, nil, routes, nil, bus, "node-b", nil)
+	go nodeB.StartNodeSubscriber()
+
+	bob := attachClient(nodeB, routes, "bob", "ios")
+	_ = routes.Unbind("bob", "ios", "node-b")
+
+	msg, err := NewMessage(ActionChatMessage, &ChatMessageContent{SendId: "alice", ReceiverId: "bob", Type: 1, Content: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//路由表里没有的用户不转发
+	nodeA.sendToUsers([]string{"bob"}, msg)
+	expectNoMessage(t, bob)
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"my-chat/internal/model"
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// 处理客户端上报的会话已读
+func (manager *ClientManager) handleSessionRead(client *Client, readData *SessionReadContent) {
+	readSeq, err := manager.sessionService.MarkRead(client.UserId, readData.TargetId, readData.Type)
+	if err != nil {
+		zlog.Warn("mark session read failed",
+			zap.String("userId", client.UserId),
+			zap.String("targetId", readData.TargetId),
+			zap.Error(err))
+		manager.sendError(client, ActionSessionRead, "", err)
+		return
+	}
+	readData.ReadSeq = readSeq
+	manager.NotifySessionRead(client.UserId, readData)
+}
+
+// NotifySessionRead 把已读状态同步给用户的所有在线设备
+func (manager *ClientManager) NotifySessionRead(userId string, readData *SessionReadContent) {
+	jsonBytes, err := NewMessage(ActionSessionRead, readData)
+	if err != nil {
+		zlog.Error("marshal session read failed", zap.Error(err))
+		return
+	}
+	manager.sendToUser(userId, jsonBytes)
+}
+
+// 处理已读回执：群聊只记录已读位置，单聊通知发送者
+func (manager *ClientManager) handleReadReceipt(client *Client, receiptData *ReadReceiptContent) {
+	msg, err := manager.chatService.MarkMessageRead(client.UserId, receiptData.MsgId)
+	if err != nil {
+		zlog.Warn("mark message read failed",
+			zap.String("userId", client.UserId),
+			zap.String("msg_id", receiptData.MsgId),
+			zap.Error(err))
+		manager.sendError(client, ActionRead, "", err)
+		return
+	}
+	if msg.Type != model.MsgTypeSingle {
+		return
+	}
+	jsonBytes, err := NewMessage(ActionRead, &ReadReceiptContent{
+		MsgId:      msg.Uuid,
+		ReaderId:   client.UserId,
+		SendId:     msg.FromUserId,
+		ReceiverId: msg.ToId,
+		Type:       msg.Type,
+		Seq:        msg.Seq,
+	})
+	if err != nil {
+		zlog.Error("marshal read receipt failed", zap.Error(err))
+		return
+	}
+	manager.sendToUser(msg.FromUserId, jsonBytes)
+}
@@ -0,0 +1,23 @@
+package websocket
+
+import (
+	"my-chat/pkg/zlog"
+
+	"go.uber.org/zap"
+)
+
+// 处理增量同步请求，结果只回给发起请求的连接
+func (manager *ClientManager) handleSync(client *Client, syncData *SyncContent) {
+	results, err := manager.chatService.SyncMessages(client.UserId, syncData.Conversations, syncData.Limit)
+	if err != nil {
+		zlog.Warn("sync messages failed", zap.String("userId", client.UserId), zap.Error(err))
+		manager.sendError(client, ActionSync, "", err)
+		return
+	}
+	jsonBytes, err := NewMessage(ActionSync, results)
+	if err != nil {
+		zlog.Error("marshal sync result failed", zap.Error(err))
+		return
+	}
+	manager.sendToClient(client, jsonBytes)
+}
@@ -7,8 +7,10 @@ var (
 	ErrDatabase         = New(10003, "Invalid token")
 
 	ErrTokenInvalid      = New(20101, "Token invalid")
+	ErrAdminDenied       = New(20105, "Admin permission required")
 	ErrPasswordIncorrect = New(20102, "Incorrect password")
 	ErrUserBanned        = New(20103, "User not found")
+	ErrTooManyUsers      = New(20104, "Too many users in one query")
 
 	ErrVerifyCodeInvalid = New(20201, "Verification code invalid")
 	ErrUserAlreadyExist  = New(20202, "User already exists")
@@ -16,8 +18,41 @@ var (
 
 	ErrContactNotFound = New(20301, "Contact not found")
 	ErrAlreadyFriend   = New(20302, "Already friends")
+	ErrNotFriend       = New(20303, "Not friends")
+	ErrBlacklisted     = New(20304, "Blocked by blacklist")
 
 	ErrGroupNotFound  = New(30401, "Group not found")
 	ErrGroupFull      = New(30402, "Group full")
 	ErrNotGroupMember = New(30403, "not a member of this group")
+	ErrGroupBanned    = New(30404, "Group is disabled")
+
+	ErrMessageNotFound = New(40001, "Message not found")
+	ErrMessageRecalled = New(40002, "Message already recalled")
+	ErrRecallDenied    = New(40003, "No permission to recall this message")
+	ErrRecallTimeout   = New(40004, "Recall time limit exceeded")
+	ErrNotParticipant  = New(40005, "Not a participant of this conversation")
+	ErrInvalidMedia    = New(40006, "Invalid media payload")
+	ErrFileNotFound    = New(40007, "Attachment not found")
+	ErrFileDenied      = New(40008, "Attachment does not belong to sender")
+	ErrFileTooLarge    = New(40009, "Attachment too large")
+	ErrFileForbidden   = New(40010, "No permission to access this attachment")
+	ErrUploadNotFound  = New(40011, "Upload session not found or expired")
+	ErrChunkInvalid    = New(40012, "Invalid chunk")
+	ErrUploadPartial   = New(40013, "Upload not complete, chunks missing")
+	ErrChecksum        = New(40014, "Checksum mismatch")
+	ErrEditDenied      = New(40015, "No permission to edit this message")
+	ErrEditTimeout     = New(40016, "Edit time limit exceeded")
+	ErrReplyInvalid    = New(40017, "Invalid reply or thread target")
+	ErrReactionInvalid = New(40018, "Invalid reaction")
+	ErrMentionInvalid  = New(40019, "Invalid mention")
+	ErrMentionDenied   = New(40020, "Only group owner or admins can mention all")
+	ErrForwardInvalid  = New(40021, "Invalid forward request")
+	ErrPinDenied       = New(40022, "Only group owner or admins can pin messages")
+	ErrPinLimit        = New(40023, "Too many pinned messages in this conversation")
+	ErrTTLInvalid      = New(40024, "Invalid message timer")
+	ErrSettingDenied   = New(40025, "Only group owner or admins can change conversation settings")
+	ErrUploadBusy      = New(40026, "Upload is being completed")
+	ErrSyncTooMany     = New(40027, "Too many conversations in one sync request")
+
+	ErrDeadLetterNotFound = New(50001, "Dead letter not found")
 )
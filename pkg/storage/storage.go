@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Storage 附件存储后端，key为相对路径，比如 ab/abcdef....jpg
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，不存在不算错
+	Delete(ctx context.Context, key string) error
+	// SignURL 生成限时访问地址，过期后失效
+	SignURL(key string, expire time.Duration) (string, error)
+}
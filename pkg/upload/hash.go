@@ -0,0 +1,24 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// HashContent 计算内容的sha256，读完后回到开头
+func HashContent(r io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashKey 按内容哈希分目录存放，避免单目录文件过多：ab/abcdef....ext
+func HashKey(hash, suffix string) string {
+	return hash[:2] + "/" + hash + suffix
+}
@@ -0,0 +1,74 @@
+package upload
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 附件大类，决定大小限制和能发成哪种消息
+const (
+	CategoryImage = "image"
+	CategoryAudio = "audio"
+	CategoryVideo = "video"
+	CategoryFile  = "file"
+)
+
+// 落盘扩展名由嗅探出的类型决定，不用客户端给的文件名，避免把html之类的内容以可执行类型对外提供
+var mimeExt = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+	"audio/aiff":      ".aiff",
+	"audio/midi":      ".mid",
+	"application/ogg": ".ogg",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"video/avi":       ".avi",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+}
+
+// ExtByMime 识别不了的类型统一存成.bin
+func ExtByMime(mimeType string) string {
+	if ext, ok := mimeExt[mimeType]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// SniffMime 按文件头判断真实类型，不看扩展名
+func SniffMime(r io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	mimeType := http.DetectContentType(head[:n])
+	//去掉 "; charset=utf-8" 之类的参数
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = strings.TrimSpace(mimeType[:i])
+	}
+	return mimeType, nil
+}
+
+// Category 按MIME归类，识别不了的都算普通文件
+func Category(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return CategoryImage
+	case strings.HasPrefix(mimeType, "audio/"), mimeType == "application/ogg":
+		return CategoryAudio
+	case strings.HasPrefix(mimeType, "video/"):
+		return CategoryVideo
+	default:
+		return CategoryFile
+	}
+}
@@ -0,0 +1,89 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif" //注册解码器
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// 缩略图JPEG质量
+const thumbnailQuality = 80
+
+// MaxThumbnailPixels 超过这个像素数的图片不生成缩略图，解码要把整张图读进内存，小文件也可能是超大尺寸的压缩炸弹
+const MaxThumbnailPixels = 40 * 1000 * 1000
+
+// ImageSize 只读图片头拿宽高
+func ImageSize(r io.Reader) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// MakeThumbnail 等比缩放到长边不超过maxSide，输出JPEG；原图更小时不放大
+func MakeThumbnail(r io.Reader, w io.Writer, maxSide int) error {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstW, dstH := width, height
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			dstW, dstH = maxSide, height*maxSide/width
+		} else {
+			dstW, dstH = width*maxSide/height, maxSide
+		}
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+	return jpeg.Encode(w, scaleDown(src, dstW, dstH), &jpeg.Options{Quality: thumbnailQuality})
+}
+
+// 区域平均缩小，每个目标像素取对应源区域的均值，比最近邻少锯齿
+func scaleDown(src image.Image, dstW, dstH int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := bounds.Min.Y + (y+1)*srcH/dstH
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := bounds.Min.X + (x+1)*srcW/dstW
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}